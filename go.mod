@@ -4,9 +4,13 @@ go 1.20
 
 require (
 	github.com/fatih/color v1.13.0
+	github.com/klauspost/compress v1.17.4
 	github.com/mitchellh/hashstructure/v2 v2.0.2
 	github.com/package-url/packageurl-go v0.1.1
+	github.com/pierrec/lz4/v4 v4.1.21
 	github.com/spdx/tools-golang v0.5.0
+	github.com/ulikunitz/xz v0.5.12
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -8,6 +8,7 @@ import (
 	"github.com/Hitachi/spirat/pkgmanager"
 	"github.com/Hitachi/spirat/reporter"
 	"github.com/Hitachi/spirat/spirat"
+	"github.com/Hitachi/spirat/utils"
 	"github.com/spdx/tools-golang/spdx"
 	"io"
 	"os"
@@ -24,6 +25,11 @@ var (
 	verbose bool
 
 	toolNames string
+	files     bool
+	verify    bool
+	omitDev   bool
+	path      string
+	root      string
 
 	format   formatType
 	filename string
@@ -77,6 +83,11 @@ func init() {
 	flag.BoolVar(&verbose, "verbose", false, "output verbose log")
 
 	flag.StringVar(&toolNames, "tools", "", "output packages installed by the comma-separated specified tools")
+	flag.BoolVar(&files, "files", false, "output files installed by each package with their checksums (dpkg only)")
+	flag.BoolVar(&verify, "verify", false, "verify installed files against the checksums recorded by dpkg and rpm")
+	flag.BoolVar(&omitDev, "omit-dev", false, "leave out packages only needed for development (npm, yarn and pnpm only)")
+	flag.StringVar(&path, "path", "", "search the specified directory recursively for projects (npm, yarn and pnpm only)")
+	flag.StringVar(&root, "root", "", "query the system whose file system is at the specified directory (dpkg and rpm only)")
 
 	flag.Var(&formatFlag{}, "format", "specify file format: plain, json, spdx-json")
 	flag.BoolVar(&force, "force", false, "overwrite existing file")
@@ -96,6 +107,8 @@ func main() {
 	prepareFlags()
 	diffJson := createBaseJsonForDiff()
 
+	pkgmanager.SetOptions(pkgmanager.Options{Files: files, Verify: verify, OmitDev: omitDev, Path: path, Root: root})
+
 	managers := getPackageManagers(toolNames)
 	if len(managers) == 0 {
 		exitWithMessage("no tool specified")
@@ -196,6 +209,27 @@ func runSpirat(managers []pkgmanager.PackageManager) (*spirat.Spirat, []error) {
 	var allErrs []error
 
 	for _, manager := range managers {
+		if m, ok := manager.(pkgmanager.ProjectPackageManager); ok {
+			results, errs := m.QueryProjects()
+			if results == nil {
+				if len(errs) == 1 {
+					exitIfError(errs[0])
+				}
+
+				exitIfError(fmt.Errorf("%v", errs))
+			}
+
+			for _, path := range utils.SortedKeys(results) {
+				q.Results = append(q.Results, &spirat.Result{
+					PackageManager: manager.String(),
+					Path:           path,
+					QueryResult:    results[path],
+				})
+			}
+			allErrs = append(allErrs, errs...)
+			continue
+		}
+
 		var result *pkgmanager.QueryResult
 		var errs []error
 
@@ -0,0 +1,283 @@
+package pkgmanager
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	aptListsDirPath    = "/var/lib/apt/lists"
+	aptSourcesListPath = "/etc/apt/sources.list"
+	aptSourcesDirPath  = "/etc/apt/sources.list.d"
+)
+
+// aptArchive is a package file that can be downloaded from an apt repository.
+type aptArchive struct {
+	url    string
+	sha256 string
+	// origin describes the index the package was found in like `APT-Sources` of `apt info`, e.g.
+	// `http://deb.debian.org/debian bookworm/main amd64 Packages`.
+	origin string
+}
+
+// aptIndex is a `Packages` index downloaded by apt.
+type aptIndex struct {
+	path string
+	// uri is the base URI of the repository, to which the `Filename` field of a package is relative.
+	uri       string
+	suite     string
+	component string
+	arch      string
+}
+
+func (i *aptIndex) origin() string {
+	if i.suite == "" {
+		return i.uri + " Packages"
+	}
+	return fmt.Sprintf("%s %s/%s %s Packages", i.uri, i.suite, i.component, i.arch)
+}
+
+// queryAptArchives looks up the installed packages in the indexes under /var/lib/apt/lists, which is done offline. The
+// first index that lists the same name, version and architecture as an installed package wins.
+func (d *dpkg) queryAptArchives(entries []*dpkgEntry) (map[PackageID]*aptArchive, []error) {
+	wanted := make(map[string]*dpkgEntry)
+	for _, e := range entries {
+		wanted[d.aptKey(e.name, e.version, e.arch)] = e
+	}
+
+	indexes := d.findAptIndexes()
+	if len(indexes) == 0 {
+		// Images often ship without the indexes, which leaves nothing to look up.
+		return nil, []error{fmt.Errorf("failed to find the archives of the packages: no apt indexes in %s", aptListsDirPath)}
+	}
+
+	ret := make(map[PackageID]*aptArchive)
+	// found holds the packages listed in an index, including those without an archive.
+	found := make(map[PackageID]struct{})
+	var errs []error
+	read := false
+	for _, index := range indexes {
+		err := d.readAptIndex(index.path, func(p deb822Paragraph) error {
+			e, ok := wanted[d.aptKey(p.get("Package"), p.get("Version"), p.get("Architecture"))]
+			if !ok {
+				return nil
+			}
+			if _, ok := ret[e.id()]; ok {
+				return nil
+			}
+			found[e.id()] = struct{}{}
+
+			filename := p.get("Filename")
+			sha256 := p.get("SHA256")
+			if filename == "" || sha256 == "" {
+				errs = append(errs, fmt.Errorf("failed to find the archive of %s in %s: missing Filename or SHA256", e.name, index.path))
+				return nil
+			}
+
+			ret[e.id()] = &aptArchive{
+				url:    strings.TrimSuffix(index.uri, "/") + "/" + strings.TrimPrefix(filename, "./"),
+				sha256: sha256,
+				origin: index.origin(),
+			}
+			return nil
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to read %s: %v", index.path, err))
+			continue
+		}
+		read = true
+	}
+	if !read {
+		return ret, errs
+	}
+
+	// Packages installed from a file or from a repository whose index is gone have no archive to download.
+	for _, e := range entries {
+		if _, ok := found[e.id()]; !ok {
+			errs = append(errs, fmt.Errorf("failed to find the archive of %s %s (%s) in the apt indexes", e.name, e.version, e.arch))
+		}
+	}
+
+	return ret, errs
+}
+
+func (d *dpkg) aptKey(name, version, arch string) string {
+	return name + " " + version + " " + arch
+}
+
+// findAptIndexes returns the `Packages` indexes under /var/lib/apt/lists in a stable order. The repository of each
+// index is recovered from the file name, which apt derives from the URI of the index, e.g.
+// `deb.debian.org_debian_dists_bookworm_main_binary-amd64_Packages`.
+func (d *dpkg) findAptIndexes() []*aptIndex {
+	var paths []string
+	for _, pattern := range []string{"*_Packages", "*_Packages.gz", "*_Packages.lz4", "*_Packages.xz", "*_Packages.zst"} {
+		matches, _ := filepath.Glob(filepath.Join(rootPath(aptListsDirPath), pattern))
+		paths = append(paths, matches...)
+	}
+	sort.Strings(paths)
+
+	uris := d.readAptSourceURIs()
+
+	var indexes []*aptIndex
+	for _, path := range paths {
+		name := filepath.Base(path)
+		name = name[:strings.LastIndex(name, "_Packages")]
+
+		index := &aptIndex{path: path}
+		repo, dist, ok := strings.Cut(name, "_dists_")
+		if ok {
+			// The suite may contain slashes, which are escaped like the other slashes in the URI.
+			parts := strings.Split(dist, "_")
+			if len(parts) < 3 {
+				continue
+			}
+			index.suite = aptUnescape(strings.Join(parts[:len(parts)-2], "/"))
+			index.component = aptUnescape(parts[len(parts)-2])
+			index.arch = strings.TrimPrefix(aptUnescape(parts[len(parts)-1]), "binary-")
+		} else {
+			// Flat repositories have the index at the top, e.g. `example.com_repo_._Packages`.
+			repo = strings.TrimSuffix(strings.TrimSuffix(repo, "_."), "_")
+		}
+
+		if uri, ok := uris[repo]; ok {
+			index.uri = uri
+		} else {
+			// apt drops the scheme from the file name, so the index is assumed to be downloaded via HTTP.
+			index.uri = "http://" + aptUnescape(strings.ReplaceAll(repo, "_", "/"))
+		}
+		indexes = append(indexes, index)
+	}
+
+	return indexes
+}
+
+// readAptSourceURIs returns the repository URIs configured in the one-line and deb822-style sources of apt keyed by the
+// escaped form used in the file names under /var/lib/apt/lists.
+func (d *dpkg) readAptSourceURIs() map[string]string {
+	var uris []string
+
+	lists, _ := filepath.Glob(filepath.Join(rootPath(aptSourcesDirPath), "*.list"))
+	for _, path := range append([]string{rootPath(aptSourcesListPath)}, lists...) {
+		bytes, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+
+		for _, line := range strings.Split(string(bytes), "\n") {
+			line, _, _ = strings.Cut(line, "#")
+			fields := strings.Fields(line)
+			if len(fields) < 2 || fields[0] != "deb" {
+				continue
+			}
+
+			// Skip options such as `[arch=amd64 signed-by=...]`.
+			i := 1
+			if strings.HasPrefix(fields[i], "[") {
+				for i < len(fields) && !strings.HasSuffix(fields[i], "]") {
+					i++
+				}
+				i++
+			}
+			if i < len(fields) {
+				uris = append(uris, fields[i])
+			}
+		}
+	}
+
+	sources, _ := filepath.Glob(filepath.Join(rootPath(aptSourcesDirPath), "*.sources"))
+	for _, path := range sources {
+		file, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+
+		_ = readDeb822(file, func(p deb822Paragraph) error {
+			if strings.EqualFold(p.get("Enabled"), "no") || !strings.Contains(" "+p.get("Types")+" ", " deb ") {
+				return nil
+			}
+			uris = append(uris, strings.Fields(p.get("URIs"))...)
+			return nil
+		})
+		file.Close()
+	}
+
+	ret := make(map[string]string)
+	for _, uri := range uris {
+		ret[aptEscape(uri)] = strings.TrimSuffix(uri, "/")
+	}
+
+	return ret
+}
+
+// readAptIndex calls fn for each paragraph of an index, which is stored as is or compressed with gzip, LZ4, xz or zstd.
+func (d *dpkg) readAptIndex(path string, fn func(deb822Paragraph) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var r io.Reader = bufio.NewReader(file)
+	switch filepath.Ext(path) {
+	case ".gz":
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	case ".lz4":
+		r = lz4.NewReader(r)
+	case ".xz":
+		x, err := xz.NewReader(r)
+		if err != nil {
+			return err
+		}
+		r = x
+	case ".zst":
+		z, err := zstd.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer z.Close()
+		r = z
+	}
+
+	return readDeb822(r, fn)
+}
+
+// aptEscape converts a URI into the form that apt uses in the file names under /var/lib/apt/lists. The scheme and the
+// credentials are dropped, underscores are escaped and slashes are replaced with underscores.
+func aptEscape(uri string) string {
+	if _, rest, ok := strings.Cut(uri, "://"); ok {
+		uri = rest
+	} else if _, rest, ok := strings.Cut(uri, ":"); ok {
+		uri = rest
+	}
+	if host, path, ok := strings.Cut(uri, "/"); ok {
+		if _, h, ok := strings.Cut(host, "@"); ok {
+			uri = h + "/" + path
+		}
+	}
+
+	uri = strings.TrimSuffix(strings.TrimPrefix(uri, "/"), "/")
+	uri = strings.ReplaceAll(uri, "_", "%5f")
+	return strings.ReplaceAll(uri, "/", "_")
+}
+
+func aptUnescape(s string) string {
+	if unescaped, err := url.PathUnescape(s); err == nil {
+		return unescaped
+	}
+	return s
+}
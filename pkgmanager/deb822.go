@@ -0,0 +1,82 @@
+package pkgmanager
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// deb822Paragraph is a paragraph of the RFC 822 style control files used by dpkg and apt. Field names are
+// case-insensitive, so they are stored in lower case. Additional information about the format can be found on the
+// following website: https://www.debian.org/doc/debian-policy/ch-controlfields.html
+type deb822Paragraph map[string]string
+
+func (p deb822Paragraph) get(field string) string {
+	return p[strings.ToLower(field)]
+}
+
+// readDeb822 reads paragraphs separated by blank lines from r and calls fn for each of them. The value of a multiline
+// field keeps its line breaks: the first line holds the text after the colon, and continuation lines follow with their
+// leading space removed and a line consisting of a single `.` converted to an empty line.
+func readDeb822(r io.Reader, fn func(deb822Paragraph) error) error {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	paragraph := make(deb822Paragraph)
+	field := ""
+	lineNo := 0
+	flush := func() error {
+		if len(paragraph) == 0 {
+			return nil
+		}
+
+		err := fn(paragraph)
+		paragraph = make(deb822Paragraph)
+		field = ""
+		return err
+	}
+
+	for s.Scan() {
+		lineNo++
+		line := s.Text()
+
+		if strings.TrimSpace(line) == "" {
+			if err := flush(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if line[0] == ' ' || line[0] == '\t' {
+			if field == "" {
+				return fmt.Errorf("line %d: continuation line without a field", lineNo)
+			}
+
+			line = line[1:]
+			if strings.TrimSpace(line) == "." {
+				line = ""
+			}
+			paragraph[field] += "\n" + line
+			continue
+		}
+
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return fmt.Errorf("line %d: missing colon in %q", lineNo, line)
+		}
+
+		field = strings.ToLower(strings.TrimSpace(name))
+		paragraph[field] = strings.TrimSpace(value)
+	}
+
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	return flush()
+}
@@ -0,0 +1,100 @@
+package pkgmanager
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadDeb822(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		input   string
+		want    []deb822Paragraph
+		wantErr string
+	}{
+		{
+			name:  "paragraphs",
+			input: "Package: a\nVersion: 1.0\n\n\nPackage: b\nVersion: 2.0\n",
+			want:  []deb822Paragraph{{"package": "a", "version": "1.0"}, {"package": "b", "version": "2.0"}},
+		},
+		{
+			name:  "case-insensitive names and trimmed values",
+			input: "PACKAGE:  a  \nversion:1.0\n",
+			want:  []deb822Paragraph{{"package": "a", "version": "1.0"}},
+		},
+		{
+			name:  "continuation lines",
+			input: "Package: a\nDescription: short\n long\n .\n \tindented\nVersion: 1.0\n",
+			want: []deb822Paragraph{{
+				"package":     "a",
+				"description": "short\nlong\n\n\tindented",
+				"version":     "1.0",
+			}},
+		},
+		{
+			name:  "continuation lines of an empty first line",
+			input: "Conffiles:\n /etc/a 0123\n /etc/b 4567\n",
+			want:  []deb822Paragraph{{"conffiles": "\n/etc/a 0123\n/etc/b 4567"}},
+		},
+		{
+			name:  "comments and blank lines with spaces",
+			input: "# comment\nPackage: a\n# comment\n \t\nPackage: b\n",
+			want:  []deb822Paragraph{{"package": "a"}, {"package": "b"}},
+		},
+		{
+			name:  "colons in values",
+			input: "Homepage: https://example.com:8080/\n",
+			want:  []deb822Paragraph{{"homepage": "https://example.com:8080/"}},
+		},
+		{
+			name:  "no trailing newline",
+			input: "Package: a",
+			want:  []deb822Paragraph{{"package": "a"}},
+		},
+		{
+			name:  "empty",
+			input: "\n\n",
+		},
+		{
+			name:    "continuation line without a field",
+			input:   "Package: a\n\n continued\n",
+			want:    []deb822Paragraph{{"package": "a"}},
+			wantErr: "line 3: continuation line without a field",
+		},
+		{
+			name:    "missing colon",
+			input:   "Package: a\nbroken\n",
+			wantErr: `line 2: missing colon in "broken"`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []deb822Paragraph
+			err := readDeb822(strings.NewReader(tt.input), func(p deb822Paragraph) error {
+				got = append(got, p)
+				return nil
+			})
+
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("readDeb822() error = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Errorf("readDeb822() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readDeb822() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeb822ParagraphGet(t *testing.T) {
+	p := deb822Paragraph{"package": "a"}
+	if got := p.get("Package"); got != "a" {
+		t.Errorf("get(Package) = %q, want %q", got, "a")
+	}
+	if got := p.get("Version"); got != "" {
+		t.Errorf("get(Version) = %q, want an empty string", got)
+	}
+}
@@ -5,95 +5,108 @@ import (
 	"github.com/Hitachi/spirat/sysinfo"
 	"github.com/package-url/packageurl-go"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"regexp"
 	"strings"
 )
 
 var (
-	formatRe  = regexp.MustCompile(`Format:(.+)`)
-	licenseRe = regexp.MustCompile(`License:(.+)`)
+	formatRe = regexp.MustCompile(`(?m)^Format:(.+)`)
+)
+
+const (
+	dpkgStatusPath    = "/var/lib/dpkg/status"
+	dpkgStatusDirPath = "/var/lib/dpkg/status.d"
 )
 
 type dpkg struct{}
 
 func (d *dpkg) Query() (*QueryResult, []error) {
-	names, err := d.queryNames()
-	if err != nil {
-		return nil, []error{err}
-	}
-
-	versions, err := d.queryVersions()
-	if err != nil {
-		return nil, []error{err}
-	}
-
-	if len(names) != len(versions) {
-		return nil, []error{fmt.Errorf("names and versions should be the same length")}
-	}
-
-	architectures, err := d.queryArchitectures()
-	if err != nil {
-		return nil, []error{err}
+	entries, errs := d.readStatus()
+	if entries == nil && errs != nil {
+		return nil, errs
 	}
 
-	if len(names) != len(architectures) {
-		return nil, []error{fmt.Errorf("names and versions should be the same length")}
-	}
-
-	homepages, err := d.queryHomepages()
-	if err != nil {
-		return nil, []error{err}
-	}
-
-	if len(names) != len(homepages) {
-		return nil, []error{fmt.Errorf("names and homepages should be the same length")}
-	}
-
-	osRelease := sysinfo.NewOSRelease()
-	aptSourcesMap := d.queryAptSources(names)
+	osRelease := sysinfo.NewOSRelease(options.Root)
+	archives, archiveErrs := d.queryAptArchives(entries)
+	errs = append(errs, archiveErrs...)
 
 	pkgs := make(map[PackageID]*Package)
-	var errs []error
-	for i := 0; i < len(names); i++ {
-		name := names[i]
-		version := versions[i]
-		arch := architectures[i]
+	recorded := make(map[PackageID][]*File)
+	for _, e := range entries {
+		name := e.name
+		version := e.version
 
-		licenses, err := d.queryLicense(name)
+		copyright, err := d.queryCopyright(name)
 		if err != nil {
-			err := fmt.Errorf("failed to find licenses in %s: %v", name, err)
+			err := fmt.Errorf("failed to read the copyright file of %s: %v", name, err)
 			errs = append(errs, err)
 		}
 
-		licenseFiles, err := d.queryLicenseFiles(name)
+		licenseFiles, err := d.queryLicenseFiles(name, copyright)
 		if err != nil {
 			err := fmt.Errorf("failed to find licenses in %s: %v", name, err)
 			errs = append(errs, err)
 		}
 
 		pkg := &Package{
-			ID:           packageID(name, version),
-			Name:         name,
-			Version:      version,
-			Licenses:     licenses,
-			HomepageUrl:  homepages[i],
-			SourceInfo:   aptSourcesMap[name],
-			Filename:     d.constructFilename(name, version, arch),
-			LicenseFiles: licenseFiles,
-			PackageURL: packageurl.NewPackageURL(
-				packageurl.TypeDebian,
-				osRelease.ID,
-				name,
-				version,
-				packageurl.Qualifiers{},
-				"",
-			),
+			ID:            e.id(),
+			Name:          name,
+			Version:       version,
+			Architecture:  e.arch,
+			Licenses:      d.findLicensesOfMachineReadableCopyright(copyright),
+			HomepageUrl:   e.homepage,
+			Filename:      d.constructFilename(name, version, e.arch),
+			LicenseFiles:  licenseFiles,
+			CopyrightText: copyright.copyrightText(),
+			Copyrights:    copyright.copyrights(),
+			PackageURL: d.purl(osRelease, name, version, map[string]string{
+				"arch":     e.arch,
+				"upstream": d.upstream(e),
+			}),
+			Source: &SourcePackage{
+				ID:         packageID("src", e.sourceName, e.sourceVersion),
+				Name:       e.sourceName,
+				Version:    e.sourceVersion,
+				PackageURL: d.purl(osRelease, e.sourceName, e.sourceVersion, map[string]string{"arch": "source"}),
+			},
+		}
+
+		if archive, ok := archives[e.id()]; ok {
+			pkg.DownloadUrl = archive.url
+			pkg.SourceInfo = "acquired from " + archive.origin
+			pkg.Checksums = []*Checksum{{Algorithm: ChecksumSHA256, Value: archive.sha256}}
+		}
+
+		if options.Files {
+			files, fileErrs := d.queryFiles(e)
+			errs = append(errs, fileErrs...)
+			pkg.Files = files
+		}
+
+		if options.Verify {
+			files, err := d.queryRecordedFiles(e)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to read checksums of %s: %v", name, err))
+			}
+			recorded[e.id()] = files
+		}
+
+		pkgs[e.id()] = pkg
+	}
+
+	if options.Verify {
+		verifications, verifyErrs := verifyFiles(recorded)
+		errs = append(errs, verifyErrs...)
+		for id, v := range verifications {
+			pkgs[id].Verification = v
 		}
-		pkgs[packageID(name, version)] = pkg
 	}
 
-	queryResult := &QueryResult{Packages: pkgs}
+	queryResult := &QueryResult{
+		Packages:     pkgs,
+		Dependencies: d.queryDependencies(entries),
+	}
 
 	if len(errs) > 0 {
 		return queryResult, errs
@@ -102,136 +115,227 @@ func (d *dpkg) Query() (*QueryResult, []error) {
 	return queryResult, nil
 }
 
-func (d *dpkg) String() string {
-	return "dpkg"
+// purl returns the package URL of a binary or source package. The epoch is moved from the version to the `epoch`
+// qualifier, and the `distro` qualifier is derived from os-release, e.g.
+// `pkg:deb/debian/libc6@2.36-9?arch=amd64&distro=debian-12`.
+func (d *dpkg) purl(osRelease sysinfo.OSRelease, name, version string, qualifiers map[string]string) *packageurl.PackageURL {
+	epoch, version := d.splitEpoch(version)
+	if epoch != "" {
+		qualifiers["epoch"] = epoch
+	}
+
+	qualifiers["distro"] = osRelease.Distro()
+
+	for k, v := range qualifiers {
+		if v == "" {
+			delete(qualifiers, k)
+		}
+	}
+
+	return packageurl.NewPackageURL(
+		packageurl.TypeDebian,
+		osRelease.ID,
+		name,
+		version,
+		packageurl.QualifiersFromMap(qualifiers),
+		"",
+	)
 }
 
-func (d *dpkg) Available() bool {
-	return hasCommand("dpkg-query")
+// upstream returns the `upstream` qualifier naming the source package of e, with its version only if it differs from
+// the version of the binary package. It is empty if both the name and the version are the same.
+func (d *dpkg) upstream(e *dpkgEntry) string {
+	switch {
+	case e.sourceVersion != e.version:
+		return e.sourceName + "@" + e.sourceVersion
+	case e.sourceName != e.name:
+		return e.sourceName
+	default:
+		return ""
+	}
 }
 
-func (d *dpkg) extractInstalled(lines []string) []string {
-	var ret []string
+func (d *dpkg) String() string {
+	return "dpkg"
+}
 
-	for _, line := range lines {
-		split := strings.SplitN(line, " ", 2)
-		status := split[0]
-		name := strings.TrimLeft(split[1], " ")
-		if status == "ii" {
-			ret = append(ret, name)
-		}
+func (d *dpkg) Available() bool {
+	if _, err := os.Stat(rootPath(dpkgStatusPath)); err == nil {
+		return true
 	}
 
-	return ret
+	_, err := os.Stat(rootPath(dpkgStatusDirPath))
+	return err == nil
 }
 
-func (d *dpkg) queryNames() ([]string, error) {
-	cmd := exec.Command("dpkg-query", "-W", "-f", "${db:Status-Abbrev} ${Package}\\n")
-	output, err := cmd.Output()
-	if err != nil {
-		return nil, err
-	}
+// dpkgEntry is an installed package read from a paragraph of the dpkg status database.
+type dpkgEntry struct {
+	name     string
+	version  string
+	arch     string
+	homepage string
+	// sourceName and sourceVersion identify the source package the binary package was built from.
+	sourceName    string
+	sourceVersion string
+	// md5sums is the path of the file listing the MD5 digests of the installed files, if it is known.
+	md5sums string
+	control deb822Paragraph
+}
 
-	return d.extractInstalled(strings.Split(strings.TrimSuffix(string(output), "\n"), "\n")), nil
+// id identifies e by its architecture in addition to its name and version, as packages of multiple architectures can be
+// installed at the same time, e.g. libc6:amd64 and libc6:i386.
+func (e *dpkgEntry) id() PackageID {
+	return packageID(e.name, e.version, e.arch)
 }
 
-func (d *dpkg) queryVersions() ([]string, error) {
-	cmd := exec.Command("dpkg-query", "-W", "-f", "${db:Status-Abbrev} ${Version}\\n")
-	output, err := cmd.Output()
-	if err != nil {
-		return nil, err
+// readStatus returns the installed packages recorded in the dpkg status database and in the per-package status files
+// under status.d. A package found in both places is only returned once, with the entry of the status database winning.
+func (d *dpkg) readStatus() ([]*dpkgEntry, []error) {
+	var entries []*dpkgEntry
+	var errs []error
+
+	path := rootPath(dpkgStatusPath)
+	if _, err := os.Stat(path); err == nil {
+		entries, err = d.readStatusFile(path, true)
+		if err != nil {
+			return nil, []error{err}
+		}
 	}
 
-	versions := d.extractInstalled(strings.Split(strings.TrimSuffix(string(output), "\n"), "\n"))
-	for i, version := range versions {
-		colon := strings.Index(version, ":")
-		if colon != -1 {
-			versions[i] = version[colon+1:]
+	dirEntries, dirErrs := d.readStatusDir()
+	errs = append(errs, dirErrs...)
+
+	seen := make(map[string]struct{})
+	for _, e := range entries {
+		seen[e.name+":"+e.arch] = struct{}{}
+	}
+	for _, e := range dirEntries {
+		if _, ok := seen[e.name+":"+e.arch]; ok {
+			continue
 		}
+		seen[e.name+":"+e.arch] = struct{}{}
+		entries = append(entries, e)
 	}
 
-	return versions, nil
+	return entries, errs
 }
 
-func (d *dpkg) queryArchitectures() ([]string, error) {
-	cmd := exec.Command("dpkg-query", "-W", "-f", "${db:Status-Abbrev} ${Architecture}\\n")
-	output, err := cmd.Output()
+// readStatusFile parses a file in the format of the dpkg status database and returns the packages in the installed
+// state. Packages that are only known to dpkg, such as removed packages whose configuration files remain, are skipped.
+// Distroless images write per-package files without a `Status` field, so it is only checked if requireStatus is true.
+func (d *dpkg) readStatusFile(path string, requireStatus bool) ([]*dpkgEntry, error) {
+	file, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
+	defer file.Close()
 
-	return d.extractInstalled(strings.Split(strings.TrimSuffix(string(output), "\n"), "\n")), nil
-}
+	var entries []*dpkgEntry
+	err = readDeb822(file, func(p deb822Paragraph) error {
+		status := p.get("Status")
+		if (requireStatus || status != "") && !d.isInstalled(status) {
+			return nil
+		}
 
-func (d *dpkg) queryHomepages() ([]string, error) {
-	cmd := exec.Command("dpkg-query", "-W", "-f", "${db:Status-Abbrev} ${Homepage}\\n")
-	output, err := cmd.Output()
+		entries = append(entries, d.newEntry(p))
+		return nil
+	})
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
 	}
 
-	return d.extractInstalled(strings.Split(strings.TrimSuffix(string(output), "\n"), "\n")), nil
+	return entries, nil
 }
 
-func (d *dpkg) queryAptSources(packageNames []string) map[string]string {
-	if !hasCommand("apt") {
-		return map[string]string{}
+// readStatusDir reads the per-package status files that distroless images ship under status.d instead of the status
+// database. Each package is described by a file named after it, accompanied by a `.md5sums` file listing the digests of
+// its installed files.
+func (d *dpkg) readStatusDir() ([]*dpkgEntry, []error) {
+	dir := rootPath(dpkgStatusDirPath)
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, []error{err}
 	}
 
-	// To minimize the number of command invocations, we merge package names whenever possible.
-	var argsList [][]string
-	argN := 0
-	argI := 0
-	for _, n := range packageNames {
-		if argI == len(argsList) {
-			argsList = append(argsList, []string{"info"})
-		}
-		argsList[argI] = append(argsList[argI], n)
-		argN += len(n)
-
-		// The maximum length of arguments would be `ARG_MAX - len("apt info ") - MAXIMUM_LEN_OF_PACKAGE_NAME`, where
-		// ARG_MAX is typically 4KB or larger on POSIX-compliant operating systems. However, the specification does not
-		// seem to mention a specific value for MAXIMUM_LEN_OF_PACKAGE_NAME.
-		// As a result, we assume a value of 255 for this parameter.
-		if argN > 4096-len("apt info ")-255 {
-			argN = 0
-			argI++
+	var entries []*dpkgEntry
+	var errs []error
+	for _, f := range files {
+		if f.IsDir() || strings.HasSuffix(f.Name(), ".md5sums") {
+			continue
 		}
-	}
 
-	ret := make(map[string]string)
-	for _, args := range argsList {
-		cmd := exec.Command("apt", args...)
-		output, err := cmd.Output()
+		path := filepath.Join(dir, f.Name())
+		es, err := d.readStatusFile(path, false)
 		if err != nil {
-			return map[string]string{}
+			errs = append(errs, err)
+			continue
 		}
 
-		name := ""
-		lines := strings.Split(string(output), "\n")
-		for _, line := range lines {
-			if strings.HasPrefix(line, "Package:") {
-				pair := strings.SplitN(line, ":", 2)
-				name = strings.TrimSpace(pair[1])
+		md5sums := path + ".md5sums"
+		if _, err := os.Stat(md5sums); err == nil {
+			for _, e := range es {
+				e.md5sums = md5sums
 			}
+		}
 
-			if strings.HasPrefix(line, "APT-Sources:") && name != "" {
-				pair := strings.SplitN(line, ":", 2)
-				ret[name] = strings.TrimSpace(pair[1])
-			}
+		entries = append(entries, es...)
+	}
+
+	return entries, errs
+}
+
+func (d *dpkg) newEntry(p deb822Paragraph) *dpkgEntry {
+	e := &dpkgEntry{
+		name:     p.get("Package"),
+		version:  p.get("Version"),
+		arch:     p.get("Architecture"),
+		homepage: p.get("Homepage"),
+		control:  p,
+	}
+
+	// The `Source` field is omitted if the source package has the same name as the binary package, and its version is
+	// omitted if it is the same as the version of the binary package, e.g. `Source: openssl (3.0.11-1~deb12u2)`.
+	e.sourceName, e.sourceVersion = e.name, e.version
+	if source := p.get("Source"); source != "" {
+		name, version, ok := strings.Cut(source, " ")
+		e.sourceName = name
+		if ok {
+			e.sourceVersion = strings.Trim(strings.TrimSpace(version), "()")
 		}
 	}
 
-	return ret
+	return e
+}
+
+// splitEpoch splits a version in the form of `[epoch:]upstream_version[-debian_revision]` into the epoch and the rest.
+func (d *dpkg) splitEpoch(version string) (string, string) {
+	epoch, rest, ok := strings.Cut(version, ":")
+	if !ok {
+		return "", version
+	}
+
+	return epoch, rest
+}
+
+// isInstalled reports whether the `Status` field, which consists of the want, flag and status words, describes a package
+// that is currently installed.
+func (d *dpkg) isInstalled(status string) bool {
+	words := strings.Fields(status)
+	return len(words) == 3 && words[2] == "installed"
 }
 
-func (d *dpkg) queryLicense(name string) ([]*License, error) {
+// queryCopyright parses the copyright file of the specified package. If the file does not exist or is not
+// machine-readable, this method will return nil without an error.
+func (d *dpkg) queryCopyright(name string) (*dep5Copyright, error) {
 	path := d.findCopyrightPath(name)
 	if path == "" {
 		return nil, nil
 	}
 
-	bytes, err := os.ReadFile(path)
+	bytes, err := os.ReadFile(rootPath(path))
 	if err != nil {
 		return nil, err
 	}
@@ -241,20 +345,21 @@ func (d *dpkg) queryLicense(name string) ([]*License, error) {
 		return nil, err
 	}
 
-	if format != "" {
-		return d.findLicensesOfMachineReadableCopyright(string(bytes))
+	if format == "" {
+		return nil, nil
 	}
 
-	return nil, nil
+	return parseDep5(string(bytes))
 }
 
 // detectFormat returns the format of the specified text. If the detected format is not machine-readable, this method
 // will return an empty string without an error. Errors will only be returned if something goes wrong. Additional
 // information about the format can be found on the following website: https://dep-team.pages.debian.net/deps/dep5/
 func (d *dpkg) detectFormat(content string) (string, error) {
-	match := formatRe.FindStringSubmatch(content)
+	// Machine-readable files must have a line beginning with `Format: ` in the header paragraph.
+	header, _, _ := strings.Cut(strings.TrimLeft(content, "\n"), "\n\n")
+	match := formatRe.FindStringSubmatch(header)
 	if match == nil {
-		// Machine-readable files must have a line beginning with `Format: `.
 		return "", nil
 	}
 
@@ -265,9 +370,11 @@ func (d *dpkg) detectFormat(content string) (string, error) {
 	return strings.Trim(match[1], " "), nil
 }
 
+// findCopyrightPath returns the path of the copyright file of a package as seen from the system, or an empty string if
+// there is none.
 func (d *dpkg) findCopyrightPath(name string) string {
 	path := fmt.Sprintf("/usr/share/doc/%s/copyright", name)
-	if _, err := os.Stat(path); err == nil {
+	if _, err := os.Stat(rootPath(path)); err == nil {
 		return path
 	}
 
@@ -278,63 +385,72 @@ func (d *dpkg) findCopyrightPath(name string) string {
 
 	shortName := name[0:colonPos]
 	path = fmt.Sprintf("/usr/share/doc/%s/copyright", shortName)
-	if _, err := os.Stat(path); err == nil {
+	if _, err := os.Stat(rootPath(path)); err == nil {
 		return path
 	}
 
 	return ""
 }
 
-func (d *dpkg) findLicensesOfMachineReadableCopyright(content string) ([]*License, error) {
-	matches := licenseRe.FindAllStringSubmatch(content, -1)
-	if matches == nil {
-		return nil, nil
+// findLicensesOfMachineReadableCopyright splits the license expressions of a machine-readable copyright file into
+// licenses that all apply.
+func (d *dpkg) findLicensesOfMachineReadableCopyright(c *dep5Copyright) []*License {
+	if c == nil {
+		return nil
 	}
 
-	set := make(map[string]struct{})
-	for _, match := range matches {
-		licenseStr := strings.TrimSpace(match[1])
-
-		if strings.Contains(licenseStr, " or ") {
-			set["("+licenseStr+")"] = struct{}{}
-			continue
-		}
-
-		ls := strings.Split(licenseStr, " and ")
-		for _, l := range ls {
-			name := strings.TrimSpace(l)
-			set[name] = struct{}{}
-		}
+	var names []string
+	for _, expression := range c.licenseExpressions() {
+		names = append(names, splitDep5LicenseExpression(expression)...)
 	}
 
 	var licenses []*License
-	for name := range set {
-		license := License{
-			Name: name,
+	set := make(map[string]struct{})
+	for _, name := range names {
+		if _, ok := set[name]; ok || name == "" {
+			continue
 		}
-		licenses = append(licenses, &license)
+		set[name] = struct{}{}
+		licenses = append(licenses, newLicense(name))
 	}
 
-	return licenses, nil
+	return licenses
 }
 
 func (d *dpkg) constructFilename(name, version, arch string) string {
+	// The names of archives do not include the epoch.
+	_, version = d.splitEpoch(version)
 	return fmt.Sprintf("%s_%s_%s.deb", name, version, arch)
 }
 
-func (d *dpkg) queryLicenseFiles(name string) ([]*LicenseFile, error) {
+// queryLicenseFiles returns the copyright file of the specified package, followed by the files under
+// /usr/share/common-licenses that it refers to for the full license text.
+func (d *dpkg) queryLicenseFiles(name string, c *dep5Copyright) ([]*LicenseFile, error) {
 	path := d.findCopyrightPath(name)
 	if path == "" {
 		return []*LicenseFile{}, nil
 	}
 
-	bytes, err := os.ReadFile(path)
-	if err != nil {
-		return nil, err
+	paths := []string{path}
+	if c != nil {
+		paths = append(paths, c.commonLicensePaths()...)
+	}
+
+	var licenseFiles []*LicenseFile
+	for _, p := range paths {
+		bytes, err := os.ReadFile(rootPath(p))
+		if err != nil {
+			if p != path && os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+
+		licenseFiles = append(licenseFiles, &LicenseFile{
+			Path:    p,
+			Content: string(bytes),
+		})
 	}
 
-	return []*LicenseFile{{
-		Path:    path,
-		Content: string(bytes),
-	}}, nil
+	return licenseFiles, nil
 }
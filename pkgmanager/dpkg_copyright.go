@@ -0,0 +1,257 @@
+package pkgmanager
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var commonLicenseRe = regexp.MustCompile(`/usr/share/common-licenses/[A-Za-z0-9][A-Za-z0-9.+_-]*[A-Za-z0-9+]`)
+
+// dep5Copyright is a machine-readable copyright file. Additional information about the format can be found on the
+// following website: https://dep-team.pages.debian.net/deps/dep5/
+type dep5Copyright struct {
+	header deb822Paragraph
+	files  []*dep5Files
+	// licenses maps the short names of standalone License paragraphs to their license text.
+	licenses map[string]string
+}
+
+// dep5Files is a Files paragraph, which declares the copyright holders and the license of the files matching its glob
+// patterns. The patterns match the files of the source package rather than the installed files.
+type dep5Files struct {
+	patterns    []string
+	copyright   []string
+	license     string
+	licenseText string
+}
+
+// parseDep5 parses the paragraphs of a machine-readable copyright file. The first paragraph is the header, which is
+// followed by Files paragraphs and standalone License paragraphs giving the text of the licenses referenced by name.
+// A malformed paragraph is skipped without failing the others, and reported in the returned error along with the
+// copyright file made of the rest.
+func parseDep5(content string) (*dep5Copyright, error) {
+	c := &dep5Copyright{header: deb822Paragraph{}, licenses: make(map[string]string)}
+
+	var errs []error
+	first := true
+	for _, chunk := range splitDep5Paragraphs(content) {
+		var p deb822Paragraph
+		err := readDeb822(strings.NewReader(chunk.text), func(q deb822Paragraph) error {
+			p = q
+			return nil
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("skipped the paragraph at line %d: %w", chunk.line, err))
+			// A malformed header still takes the place of the header.
+			first = false
+			continue
+		}
+		if p == nil {
+			// The paragraph only consists of comments.
+			continue
+		}
+
+		switch {
+		case first:
+			c.header = p
+		case p.get("Files") != "":
+			license, text := splitDep5License(p.get("License"))
+			c.files = append(c.files, &dep5Files{
+				patterns:    strings.Fields(p.get("Files")),
+				copyright:   splitDep5Copyright(p.get("Copyright")),
+				license:     license,
+				licenseText: text,
+			})
+		case p.get("License") != "":
+			license, text := splitDep5License(p.get("License"))
+			c.licenses[license] = text
+		}
+		first = false
+	}
+
+	return c, errors.Join(errs...)
+}
+
+// dep5Paragraph is the text of a paragraph and the number of the line it starts at.
+type dep5Paragraph struct {
+	text string
+	line int
+}
+
+// splitDep5Paragraphs splits content at blank lines, which separate paragraphs as in readDeb822.
+func splitDep5Paragraphs(content string) []dep5Paragraph {
+	var paragraphs []dep5Paragraph
+	var lines []string
+	start := 0
+	flush := func() {
+		if len(lines) > 0 {
+			paragraphs = append(paragraphs, dep5Paragraph{text: strings.Join(lines, "\n"), line: start})
+			lines = nil
+		}
+	}
+
+	for i, line := range strings.Split(content, "\n") {
+		if strings.TrimSpace(line) == "" {
+			flush()
+			continue
+		}
+		if len(lines) == 0 {
+			start = i + 1
+		}
+		lines = append(lines, line)
+	}
+	flush()
+
+	return paragraphs
+}
+
+// splitDep5LicenseExpression splits a license expression of a machine-readable copyright file into licenses that all
+// apply. In the expression, `and` binds more tightly than `or`, except that a comma separates expressions of lower
+// precedence, e.g. `GPL-2+ or Artistic, and BSD-3-clause`. Disjunctions are kept as one license in parentheses.
+func splitDep5LicenseExpression(expression string) []string {
+	chunks := strings.Split(expression, ",")
+	conjunctive := true
+	for i := range chunks {
+		chunks[i] = strings.TrimSpace(chunks[i])
+		if i > 0 {
+			if rest, ok := strings.CutPrefix(chunks[i], "and "); ok {
+				chunks[i] = rest
+			} else {
+				conjunctive = false
+			}
+		}
+	}
+
+	if !conjunctive {
+		return []string{"(" + strings.Join(strings.Fields(strings.ReplaceAll(expression, ",", " ")), " ") + ")"}
+	}
+
+	var names []string
+	for _, chunk := range chunks {
+		if strings.Contains(chunk, " or ") {
+			names = append(names, "("+chunk+")")
+			continue
+		}
+
+		for _, l := range strings.Split(chunk, " and ") {
+			names = append(names, strings.TrimSpace(l))
+		}
+	}
+
+	return names
+}
+
+// splitDep5License splits the value of a License field into the license expression on its first line and the license
+// text on the following lines.
+func splitDep5License(value string) (string, string) {
+	expression, text, _ := strings.Cut(value, "\n")
+	return strings.Join(strings.Fields(expression), " "), text
+}
+
+func splitDep5Copyright(value string) []string {
+	var holders []string
+	for _, line := range strings.Split(value, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			holders = append(holders, line)
+		}
+	}
+
+	return holders
+}
+
+// licenseExpressions returns the distinct license expressions declared in the header and the Files paragraphs.
+func (c *dep5Copyright) licenseExpressions() []string {
+	var expressions []string
+	seen := make(map[string]struct{})
+
+	add := func(expression string) {
+		if _, ok := seen[expression]; expression != "" && !ok {
+			seen[expression] = struct{}{}
+			expressions = append(expressions, expression)
+		}
+	}
+
+	license, _ := splitDep5License(c.header.get("License"))
+	add(license)
+	for _, f := range c.files {
+		add(f.license)
+	}
+
+	return expressions
+}
+
+// copyrights returns the copyright holders and the license of the files matching the patterns of each Files paragraph.
+func (c *dep5Copyright) copyrights() []*Copyright {
+	if c == nil {
+		return nil
+	}
+
+	var copyrights []*Copyright
+	for _, f := range c.files {
+		copyright := &Copyright{Patterns: f.patterns, Holders: f.copyright}
+		if f.license != "" {
+			// The expression is split first, as commas have a precedence that SPDX expressions lack.
+			copyright.License = &License{
+				Name:           f.license,
+				SpdxExpression: NormalizeLicense(strings.Join(splitDep5LicenseExpression(f.license), " and ")),
+			}
+		}
+		copyrights = append(copyrights, copyright)
+	}
+
+	return copyrights
+}
+
+// copyrightText returns the distinct copyright holders of all files, one per line.
+func (c *dep5Copyright) copyrightText() string {
+	if c == nil {
+		return ""
+	}
+
+	var holders []string
+	seen := make(map[string]struct{})
+
+	all := splitDep5Copyright(c.header.get("Copyright"))
+	for _, f := range c.files {
+		all = append(all, f.copyright...)
+	}
+
+	for _, h := range all {
+		if _, ok := seen[h]; !ok {
+			seen[h] = struct{}{}
+			holders = append(holders, h)
+		}
+	}
+
+	return strings.Join(holders, "\n")
+}
+
+// commonLicensePaths returns the files under /usr/share/common-licenses that license texts refer to instead of
+// including the full text, e.g. `the complete text ... can be found in /usr/share/common-licenses/GPL-2`.
+func (c *dep5Copyright) commonLicensePaths() []string {
+	_, headerText := splitDep5License(c.header.get("License"))
+	texts := []string{headerText}
+	for _, f := range c.files {
+		texts = append(texts, f.licenseText)
+	}
+	for _, text := range c.licenses {
+		texts = append(texts, text)
+	}
+
+	var paths []string
+	seen := make(map[string]struct{})
+	for _, text := range texts {
+		for _, path := range commonLicenseRe.FindAllString(text, -1) {
+			if _, ok := seen[path]; !ok {
+				seen[path] = struct{}{}
+				paths = append(paths, path)
+			}
+		}
+	}
+
+	sort.Strings(paths)
+	return paths
+}
@@ -0,0 +1,159 @@
+package pkgmanager
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const dep5TestCopyright = `Format: https://www.debian.org/doc/packaging-manuals/copyright-format/1.0/
+Upstream-Name: hello
+Copyright: 1992-2022 Free Software Foundation, Inc.
+License: GPL-3+
+
+Files: *
+Copyright: 1992-2022 Free Software Foundation, Inc.
+License: GPL-3+
+
+# A comment between paragraphs.
+
+Files: debian/*
+ man/hello.1
+Copyright: 2010 Jane Doe
+           2012 John Roe
+License: GPL-2+ or Artistic, and BSD-3-clause
+
+Files: lib/getopt*
+Copyright: 1987-2022 Free Software Foundation, Inc.
+License: LGPL-2.1+
+ This library is free software.
+ .
+ On Debian systems, the complete text of the GNU Lesser General Public License
+ can be found in /usr/share/common-licenses/LGPL-2.1.
+
+License: GPL-3+
+ On Debian systems, the complete text of the GNU General Public License version 3
+ can be found in /usr/share/common-licenses/GPL-3.
+`
+
+func TestParseDep5(t *testing.T) {
+	c, err := parseDep5(dep5TestCopyright)
+	if err != nil {
+		t.Fatalf("parseDep5() error = %v", err)
+	}
+
+	if got := c.header.get("Upstream-Name"); got != "hello" {
+		t.Errorf("header Upstream-Name = %q, want %q", got, "hello")
+	}
+
+	wantCopyrights := []*Copyright{
+		{
+			Patterns: []string{"*"},
+			Holders:  []string{"1992-2022 Free Software Foundation, Inc."},
+			License:  &License{Name: "GPL-3+", SpdxExpression: "GPL-3.0-or-later"},
+		},
+		{
+			Patterns: []string{"debian/*", "man/hello.1"},
+			Holders:  []string{"2010 Jane Doe", "2012 John Roe"},
+			License: &License{
+				Name:           "GPL-2+ or Artistic, and BSD-3-clause",
+				SpdxExpression: "(GPL-2.0-or-later OR Artistic-1.0) AND BSD-3-Clause",
+			},
+		},
+		{
+			Patterns: []string{"lib/getopt*"},
+			Holders:  []string{"1987-2022 Free Software Foundation, Inc."},
+			License:  &License{Name: "LGPL-2.1+", SpdxExpression: "LGPL-2.1-or-later"},
+		},
+	}
+	got := c.copyrights()
+	if len(got) != len(wantCopyrights) {
+		t.Fatalf("copyrights() returned %d paragraphs, want %d", len(got), len(wantCopyrights))
+	}
+	for i := range got {
+		if !reflect.DeepEqual(got[i], wantCopyrights[i]) {
+			t.Errorf("copyrights()[%d] = %+v with license %+v, want %+v with license %+v",
+				i, got[i], got[i].License, wantCopyrights[i], wantCopyrights[i].License)
+		}
+	}
+
+	if got, want := c.files[2].licenseText, "This library is free software.\n\nOn Debian systems"; !strings.HasPrefix(got, want) {
+		t.Errorf("license text = %q, want it to start with %q", got, want)
+	}
+	if _, ok := c.licenses["GPL-3+"]; !ok {
+		t.Errorf("licenses = %v, want the standalone GPL-3+ paragraph", c.licenses)
+	}
+
+	wantExpressions := []string{"GPL-3+", "GPL-2+ or Artistic, and BSD-3-clause", "LGPL-2.1+"}
+	if got := c.licenseExpressions(); !reflect.DeepEqual(got, wantExpressions) {
+		t.Errorf("licenseExpressions() = %q, want %q", got, wantExpressions)
+	}
+
+	wantText := "1992-2022 Free Software Foundation, Inc.\n2010 Jane Doe\n2012 John Roe\n1987-2022 Free Software Foundation, Inc."
+	if got := c.copyrightText(); got != wantText {
+		t.Errorf("copyrightText() = %q, want %q", got, wantText)
+	}
+
+	wantPaths := []string{"/usr/share/common-licenses/GPL-3", "/usr/share/common-licenses/LGPL-2.1"}
+	if got := c.commonLicensePaths(); !reflect.DeepEqual(got, wantPaths) {
+		t.Errorf("commonLicensePaths() = %q, want %q", got, wantPaths)
+	}
+}
+
+func TestParseDep5Malformed(t *testing.T) {
+	content := `Format: https://www.debian.org/doc/packaging-manuals/copyright-format/1.0/
+
+Files: *
+Copyright: 2020 Jane Doe
+this line has no colon
+License: MIT
+
+Files: src/*
+Copyright: 2021 John Roe
+License: Apache-2.0
+`
+	c, err := parseDep5(content)
+	if err == nil || !strings.Contains(err.Error(), "paragraph at line 3") {
+		t.Errorf("parseDep5() error = %v, want one for the paragraph at line 3", err)
+	}
+
+	want := []*Copyright{{
+		Patterns: []string{"src/*"},
+		Holders:  []string{"2021 John Roe"},
+		License:  &License{Name: "Apache-2.0", SpdxExpression: "Apache-2.0"},
+	}}
+	if got := c.copyrights(); !reflect.DeepEqual(got, want) {
+		t.Errorf("copyrights() = %+v, want the paragraphs other than the malformed one", got)
+	}
+}
+
+func TestFindLicensesOfMachineReadableCopyright(t *testing.T) {
+	for _, tt := range []struct {
+		expressions []string
+		want        []string
+	}{
+		{[]string{"GPL-2+"}, []string{"GPL-2+"}},
+		{[]string{"GPL-2+ and BSD-3-clause"}, []string{"GPL-2+", "BSD-3-clause"}},
+		{[]string{"GPL-2+ or Artistic"}, []string{"(GPL-2+ or Artistic)"}},
+		{[]string{"GPL-2+ or Artistic, and BSD-3-clause"}, []string{"(GPL-2+ or Artistic)", "BSD-3-clause"}},
+		{[]string{"GPL-2+, or Artistic"}, []string{"(GPL-2+ or Artistic)"}},
+		{[]string{"MIT", "MIT and ISC"}, []string{"MIT", "ISC"}},
+	} {
+		c := &dep5Copyright{header: deb822Paragraph{}}
+		for _, e := range tt.expressions {
+			c.files = append(c.files, &dep5Files{license: e})
+		}
+
+		var got []string
+		for _, l := range (&dpkg{}).findLicensesOfMachineReadableCopyright(c) {
+			got = append(got, l.Name)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("findLicensesOfMachineReadableCopyright(%q) = %q, want %q", tt.expressions, got, tt.want)
+		}
+	}
+
+	if got := (&dpkg{}).findLicensesOfMachineReadableCopyright(nil); got != nil {
+		t.Errorf("findLicensesOfMachineReadableCopyright(nil) = %v, want nil", got)
+	}
+}
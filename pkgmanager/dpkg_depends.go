@@ -0,0 +1,160 @@
+package pkgmanager
+
+import "strings"
+
+// dpkgRelationFields are the relationship fields that become dependencies, in the order of their strength.
+var dpkgRelationFields = []struct {
+	field          string
+	dependencyType DependencyType
+}{
+	{"Pre-Depends", DependencyTypePreDepends},
+	{"Depends", DependencyTypeDependsOn},
+	{"Recommends", DependencyTypeRecommends},
+	{"Suggests", DependencyTypeSuggests},
+}
+
+// dpkgRelation is an alternative in a relationship field such as `Depends`. The arch is the qualifier following the
+// package name, which is either empty, `any`, `native` or an architecture name.
+type dpkgRelation struct {
+	name string
+	arch string
+}
+
+// dpkgIndex looks up installed packages by their names and by the virtual packages they provide.
+type dpkgIndex struct {
+	packages   map[string][]*dpkgEntry
+	providers  map[string][]*dpkgEntry
+	nativeArch string
+}
+
+func (d *dpkg) queryDependencies(entries []*dpkgEntry) []*PackageDependency {
+	index := d.newIndex(entries)
+
+	var deps []*PackageDependency
+	seen := make(map[PackageDependency]struct{})
+	for _, e := range entries {
+		for _, f := range dpkgRelationFields {
+			for _, alternatives := range d.parseRelations(e.control.get(f.field)) {
+				// Any alternative satisfies the relationship, so the first installed one is the dependency. Version
+				// constraints are not checked as dpkg already enforced them when the packages were installed.
+				for _, r := range alternatives {
+					required := index.resolve(r, e)
+					if required == nil {
+						continue
+					}
+
+					dep := PackageDependency{
+						RequiringPackageID: e.id(),
+						RequiredPackageID:  required.id(),
+						DependencyType:     f.dependencyType,
+					}
+					if _, ok := seen[dep]; !ok && dep.RequiringPackageID != dep.RequiredPackageID {
+						seen[dep] = struct{}{}
+						deps = append(deps, &dep)
+					}
+					break
+				}
+			}
+		}
+	}
+
+	return deps
+}
+
+// parseRelations parses the value of a relationship field into groups of alternatives, e.g.
+// `libc6 (>= 2.34), debconf (>= 0.5) | debconf-2.0, python3:any`. Version constraints, architecture restrictions and
+// build profiles are dropped.
+func (d *dpkg) parseRelations(value string) [][]dpkgRelation {
+	var groups [][]dpkgRelation
+
+	for _, group := range strings.Split(value, ",") {
+		var alternatives []dpkgRelation
+		for _, alternative := range strings.Split(group, "|") {
+			alternative = strings.TrimSpace(alternative)
+			if i := strings.IndexAny(alternative, " \t\n([<"); i != -1 {
+				alternative = alternative[:i]
+			}
+			if alternative == "" {
+				continue
+			}
+
+			name, arch, _ := strings.Cut(alternative, ":")
+			alternatives = append(alternatives, dpkgRelation{name: name, arch: arch})
+		}
+
+		if len(alternatives) > 0 {
+			groups = append(groups, alternatives)
+		}
+	}
+
+	return groups
+}
+
+func (d *dpkg) newIndex(entries []*dpkgEntry) *dpkgIndex {
+	index := &dpkgIndex{
+		packages:  make(map[string][]*dpkgEntry),
+		providers: make(map[string][]*dpkgEntry),
+	}
+
+	for _, e := range entries {
+		index.packages[e.name] = append(index.packages[e.name], e)
+
+		for _, alternatives := range d.parseRelations(e.control.get("Provides")) {
+			for _, r := range alternatives {
+				index.providers[r.name] = append(index.providers[r.name], e)
+			}
+		}
+
+		// The native architecture is the one dpkg itself is built for.
+		if e.name == "dpkg" {
+			index.nativeArch = e.arch
+		}
+	}
+
+	return index
+}
+
+// resolve returns the installed package satisfying r on behalf of the requiring package, or nil if there is none.
+// Real packages take precedence over virtual packages of the same name.
+func (i *dpkgIndex) resolve(r dpkgRelation, requiring *dpkgEntry) *dpkgEntry {
+	candidates := i.packages[r.name]
+	if found := i.pick(candidates, r.arch, requiring); found != nil {
+		return found
+	}
+
+	return i.pick(i.providers[r.name], r.arch, requiring)
+}
+
+func (i *dpkgIndex) pick(candidates []*dpkgEntry, qualifier string, requiring *dpkgEntry) *dpkgEntry {
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	var arch string
+	switch qualifier {
+	case "any":
+		return candidates[0]
+	case "native":
+		arch = i.nativeArch
+	case "":
+		arch = requiring.arch
+		if arch == "all" {
+			arch = i.nativeArch
+		}
+	default:
+		arch = qualifier
+	}
+
+	for _, c := range candidates {
+		if c.arch == arch || c.arch == "all" {
+			return c
+		}
+	}
+
+	// An unqualified relationship can also be satisfied by a `Multi-Arch: foreign` package of another architecture.
+	if qualifier == "" {
+		return candidates[0]
+	}
+
+	return nil
+}
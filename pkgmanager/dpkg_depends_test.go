@@ -0,0 +1,92 @@
+package pkgmanager
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRelations(t *testing.T) {
+	for _, tt := range []struct {
+		value string
+		want  [][]dpkgRelation
+	}{
+		{"", nil},
+		{"libc6", [][]dpkgRelation{{{name: "libc6"}}}},
+		{
+			"libc6 (>= 2.34), debconf (>= 0.5) | debconf-2.0, python3:any",
+			[][]dpkgRelation{
+				{{name: "libc6"}},
+				{{name: "debconf"}, {name: "debconf-2.0"}},
+				{{name: "python3", arch: "any"}},
+			},
+		},
+		{
+			// Architecture restrictions and build profiles are dropped, and so are line breaks.
+			"libfoo [amd64] <!nocheck>,\n libbar:i386 (= 1.0),|, gcc:native",
+			[][]dpkgRelation{
+				{{name: "libfoo"}},
+				{{name: "libbar", arch: "i386"}},
+				{{name: "gcc", arch: "native"}},
+			},
+		},
+		{"perl(>=5.36)", [][]dpkgRelation{{{name: "perl"}}}},
+	} {
+		if got := (&dpkg{}).parseRelations(tt.value); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseRelations(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestQueryDpkgDependencies(t *testing.T) {
+	d := &dpkg{}
+	var entries []*dpkgEntry
+	for _, p := range []deb822Paragraph{
+		{"package": "dpkg", "version": "1.21.22", "architecture": "amd64"},
+		{"package": "libc6", "version": "2.36-9", "architecture": "amd64"},
+		{"package": "libc6", "version": "2.36-9", "architecture": "i386"},
+		{"package": "debconf", "version": "1.5.82", "architecture": "all"},
+		{"package": "mawk", "version": "1.3.4", "architecture": "amd64", "provides": "awk", "depends": "libc6 (>= 2.34)"},
+		{"package": "python3", "version": "3.11.2", "architecture": "amd64", "multi-arch": "allowed"},
+		{
+			"package":      "app",
+			"version":      "1.0",
+			"architecture": "i386",
+			"pre-depends":  "libc6 (>= 2.34)",
+			// A virtual package, an alternative that is not installed before one that is, and a foreign architecture.
+			"depends":    "awk, missing | debconf (>= 0.5), python3:any, libc6:amd64",
+			"recommends": "libc6, not-installed",
+			"suggests":   "app",
+		},
+		{"package": "tool", "version": "1.0", "architecture": "all", "depends": "libc6, python3:native"},
+	} {
+		entries = append(entries, d.newEntry(p))
+	}
+
+	got := make(map[PackageDependency]struct{})
+	for _, dep := range d.queryDependencies(entries) {
+		got[*dep] = struct{}{}
+	}
+
+	id := func(name, version, arch string) PackageID {
+		return packageID(name, version, arch)
+	}
+	want := make(map[PackageDependency]struct{})
+	for _, dep := range []PackageDependency{
+		{id("mawk", "1.3.4", "amd64"), id("libc6", "2.36-9", "amd64"), DependencyTypeDependsOn},
+		{id("app", "1.0", "i386"), id("libc6", "2.36-9", "i386"), DependencyTypePreDepends},
+		{id("app", "1.0", "i386"), id("mawk", "1.3.4", "amd64"), DependencyTypeDependsOn},
+		{id("app", "1.0", "i386"), id("debconf", "1.5.82", "all"), DependencyTypeDependsOn},
+		{id("app", "1.0", "i386"), id("python3", "3.11.2", "amd64"), DependencyTypeDependsOn},
+		{id("app", "1.0", "i386"), id("libc6", "2.36-9", "amd64"), DependencyTypeDependsOn},
+		{id("app", "1.0", "i386"), id("libc6", "2.36-9", "i386"), DependencyTypeRecommends},
+		// Packages of the architecture all depend on the packages of the native architecture.
+		{id("tool", "1.0", "all"), id("libc6", "2.36-9", "amd64"), DependencyTypeDependsOn},
+		{id("tool", "1.0", "all"), id("python3", "3.11.2", "amd64"), DependencyTypeDependsOn},
+	} {
+		want[dep] = struct{}{}
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("queryDependencies() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,158 @@
+package pkgmanager
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const dpkgInfoDirPath = "/var/lib/dpkg/info"
+
+// queryFiles returns the regular files installed by e. Each file has the MD5 digest recorded by dpkg, if any, and the
+// SHA-1 digest of its current content, which SPDX requires for files.
+func (d *dpkg) queryFiles(e *dpkgEntry) ([]*File, []error) {
+	digests, err := d.queryDigests(e)
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	paths, err := d.queryFileList(e)
+	if err != nil {
+		return nil, []error{err}
+	}
+	if paths == nil {
+		// Distroless images have no file lists, so the files with digests are all that is known.
+		for path := range digests {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+	}
+
+	var files []*File
+	var errs []error
+	for _, path := range paths {
+		info, err := os.Lstat(rootPath(path))
+		if err != nil || !info.Mode().IsRegular() {
+			// Directories, symbolic links and files removed after installation have no content to describe.
+			continue
+		}
+
+		sum, err := digestFile(rootPath(path), ChecksumSHA1)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to read %s of %s: %w", path, e.name, err))
+			continue
+		}
+
+		file := &File{Path: path}
+		if md5sum, ok := digests[path]; ok {
+			file.Checksums = append(file.Checksums, &Checksum{Algorithm: ChecksumMD5, Value: md5sum})
+		}
+		file.Checksums = append(file.Checksums, &Checksum{Algorithm: ChecksumSHA1, Value: sum})
+		files = append(files, file)
+	}
+
+	return files, errs
+}
+
+// infoPath returns the path of a file in the dpkg info directory under Options.Root. The files of packages that can be
+// installed for multiple architectures at once are qualified with the architecture, e.g. `libc6:amd64.list`.
+func (d *dpkg) infoPath(e *dpkgEntry, ext string) string {
+	dir := rootPath(dpkgInfoDirPath)
+	path := filepath.Join(dir, e.name+":"+e.arch+ext)
+	if _, err := os.Stat(path); err == nil {
+		return path
+	}
+
+	return filepath.Join(dir, e.name+ext)
+}
+
+// queryFileList returns the paths listed in the `.list` file of e, which include directories. It returns nil without
+// an error if there is no such file.
+func (d *dpkg) queryFileList(e *dpkgEntry) ([]string, error) {
+	file, err := os.Open(d.infoPath(e, ".list"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	paths := []string{}
+	s := bufio.NewScanner(file)
+	for s.Scan() {
+		if line := s.Text(); line != "" {
+			paths = append(paths, line)
+		}
+	}
+
+	return paths, s.Err()
+}
+
+// queryDigests returns the MD5 digests recorded by dpkg keyed by absolute paths. They are read from the `.md5sums` file,
+// which does not cover configuration files, and from the `Conffiles` field of the status database.
+func (d *dpkg) queryDigests(e *dpkgEntry) (map[string]string, error) {
+	digests, err := d.queryMd5sums(e)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each line consists of the path and the digest, optionally followed by flags such as `obsolete`.
+	for _, line := range strings.Split(e.control.get("Conffiles"), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 {
+			digests[fields[0]] = fields[1]
+		}
+	}
+
+	return digests, nil
+}
+
+// queryMd5sums returns the MD5 digests listed in the `.md5sums` file of e keyed by absolute paths.
+func (d *dpkg) queryMd5sums(e *dpkgEntry) (map[string]string, error) {
+	digests := make(map[string]string)
+
+	path := e.md5sums
+	if path == "" {
+		path = d.infoPath(e, ".md5sums")
+	}
+
+	bytes, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	for _, line := range strings.Split(string(bytes), "\n") {
+		sum, name, ok := strings.Cut(line, "  ")
+		if ok {
+			digests["/"+strings.TrimPrefix(name, "/")] = sum
+		}
+	}
+
+	return digests, nil
+}
+
+// queryRecordedFiles returns the files of e with the MD5 digests recorded by dpkg for verification. Configuration files
+// are left out as they are meant to be changed by administrators.
+func (d *dpkg) queryRecordedFiles(e *dpkgEntry) ([]*File, error) {
+	digests, err := d.queryMd5sums(e)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []*File
+	for path, sum := range digests {
+		files = append(files, &File{
+			Path:      path,
+			Checksums: []*Checksum{{Algorithm: ChecksumMD5, Value: sum}},
+		})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+
+	return files, nil
+}
@@ -0,0 +1,93 @@
+package pkgmanager
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestQueryFiles(t *testing.T) {
+	root := t.TempDir()
+	writeTestFiles(t, root, map[string]string{
+		// The info files of packages installed for several architectures are qualified with the architecture.
+		"var/lib/dpkg/info/libfoo:amd64.list": "/.\n/usr\n/usr/lib/libfoo.so.1\n/usr/lib/libfoo.so\n" +
+			"/etc/foo.conf\n/usr/lib/gone\n",
+		"var/lib/dpkg/info/libfoo:amd64.md5sums": "d3b07384d113edec49eaa6238ad5ff00  usr/lib/libfoo.so.1\n",
+		"usr/lib/libfoo.so.1":                    "foo\n",
+		"etc/foo.conf":                           "changed\n",
+	})
+	if err := os.Symlink("libfoo.so.1", filepath.Join(root, "usr", "lib", "libfoo.so")); err != nil {
+		t.Fatal(err)
+	}
+	SetOptions(Options{Root: root})
+	defer SetOptions(Options{})
+
+	d := &dpkg{}
+	e := d.newEntry(deb822Paragraph{
+		"package":      "libfoo",
+		"version":      "1.0",
+		"architecture": "amd64",
+		"conffiles": "\n/etc/foo.conf 00000000000000000000000000000000\n" +
+			"/etc/old.conf 11111111111111111111111111111111 obsolete",
+	})
+
+	files, errs := d.queryFiles(e)
+	if errs != nil {
+		t.Fatalf("queryFiles() errors = %v", errs)
+	}
+	// Directories, symbolic links and missing files are left out.
+	want := []*File{
+		{Path: "/usr/lib/libfoo.so.1", Checksums: []*Checksum{
+			{Algorithm: ChecksumMD5, Value: "d3b07384d113edec49eaa6238ad5ff00"},
+			{Algorithm: ChecksumSHA1, Value: "f1d2d2f924e986ac86fdf7b36c94bcdf32beec15"},
+		}},
+		{Path: "/etc/foo.conf", Checksums: []*Checksum{
+			{Algorithm: ChecksumMD5, Value: "00000000000000000000000000000000"},
+			{Algorithm: ChecksumSHA1, Value: "2f6933b5ee0f5fdd823d9717d8729f3c2523811b"},
+		}},
+	}
+	if len(files) != len(want) {
+		t.Fatalf("queryFiles() returned %d files, want %d", len(files), len(want))
+	}
+	for i := range files {
+		if !reflect.DeepEqual(files[i], want[i]) {
+			t.Errorf("queryFiles()[%d] = %s %v, want %s %v", i, files[i].Path, files[i].Checksums, want[i].Path,
+				want[i].Checksums)
+		}
+	}
+
+	// Configuration files are not verified, as they are meant to be changed.
+	recorded, err := d.queryRecordedFiles(e)
+	wantRecorded := []*File{{Path: "/usr/lib/libfoo.so.1", Checksums: []*Checksum{
+		{Algorithm: ChecksumMD5, Value: "d3b07384d113edec49eaa6238ad5ff00"},
+	}}}
+	if err != nil || !reflect.DeepEqual(recorded, wantRecorded) {
+		t.Errorf("queryRecordedFiles() = %v, %v, want %v", recorded, err, wantRecorded)
+	}
+}
+
+func TestQueryFilesWithoutList(t *testing.T) {
+	root := t.TempDir()
+	// Distroless images keep the digests next to the status files and have no file lists.
+	writeTestFiles(t, root, map[string]string{
+		"var/lib/dpkg/status.d/foo.md5sums": "d3b07384d113edec49eaa6238ad5ff00  usr/bin/foo\n",
+		"usr/bin/foo":                       "foo\n",
+		"usr/bin/bar":                       "bar\n",
+	})
+	SetOptions(Options{Root: root})
+	defer SetOptions(Options{})
+
+	d := &dpkg{}
+	e := d.newEntry(deb822Paragraph{"package": "foo", "version": "1.0", "architecture": "amd64"})
+	e.md5sums = filepath.Join(root, "var", "lib", "dpkg", "status.d", "foo.md5sums")
+
+	files, errs := d.queryFiles(e)
+	want := []*File{{Path: "/usr/bin/foo", Checksums: []*Checksum{
+		{Algorithm: ChecksumMD5, Value: "d3b07384d113edec49eaa6238ad5ff00"},
+		{Algorithm: ChecksumSHA1, Value: "f1d2d2f924e986ac86fdf7b36c94bcdf32beec15"},
+	}}}
+	if errs != nil || len(files) != 1 || !reflect.DeepEqual(files[0], want[0]) {
+		t.Errorf("queryFiles() = %v, %v, want only the files with digests", files, errs)
+	}
+}
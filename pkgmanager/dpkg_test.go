@@ -0,0 +1,174 @@
+package pkgmanager
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeTestFiles writes files keyed by their slash-separated paths under dir.
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for path, content := range files {
+		path = filepath.Join(dir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// dpkgEntrySummary is what the tests compare of a dpkgEntry.
+type dpkgEntrySummary struct {
+	name, version, arch, sourceName, sourceVersion, md5sums string
+}
+
+func summarizeDpkgEntries(entries []*dpkgEntry) []dpkgEntrySummary {
+	var summaries []dpkgEntrySummary
+	for _, e := range entries {
+		summaries = append(summaries, dpkgEntrySummary{e.name, e.version, e.arch, e.sourceName, e.sourceVersion, e.md5sums})
+	}
+	return summaries
+}
+
+const dpkgTestStatus = `Package: libc6
+Status: install ok installed
+Architecture: amd64
+Multi-Arch: same
+Source: glibc
+Version: 2.36-9+deb12u4
+Homepage: https://www.gnu.org/software/libc/libc.html
+
+Package: libc6
+Status: install ok installed
+Architecture: i386
+Multi-Arch: same
+Source: glibc
+Version: 2.36-9+deb12u4
+
+Package: removed
+Status: deinstall ok config-files
+Architecture: amd64
+Version: 1.0
+
+Package: half
+Status: install reinstreq half-installed
+Architecture: amd64
+Version: 1.0
+
+Package: libssl3
+Status: install ok installed
+Architecture: amd64
+Source: openssl (3.0.11-1~deb12u2)
+Version: 3.0.11-1~deb12u2+b1
+`
+
+func TestReadStatus(t *testing.T) {
+	root := t.TempDir()
+	writeTestFiles(t, root, map[string]string{"var/lib/dpkg/status": dpkgTestStatus})
+	SetOptions(Options{Root: root})
+	defer SetOptions(Options{})
+
+	entries, errs := (&dpkg{}).readStatus()
+	if errs != nil {
+		t.Fatalf("readStatus() errors = %v", errs)
+	}
+
+	want := []dpkgEntrySummary{
+		{"libc6", "2.36-9+deb12u4", "amd64", "glibc", "2.36-9+deb12u4", ""},
+		{"libc6", "2.36-9+deb12u4", "i386", "glibc", "2.36-9+deb12u4", ""},
+		{"libssl3", "3.0.11-1~deb12u2+b1", "amd64", "openssl", "3.0.11-1~deb12u2", ""},
+	}
+	if got := summarizeDpkgEntries(entries); !reflect.DeepEqual(got, want) {
+		t.Errorf("readStatus() = %v, want %v", got, want)
+	}
+	if got := entries[0].homepage; got != "https://www.gnu.org/software/libc/libc.html" {
+		t.Errorf("homepage = %q", got)
+	}
+	if got := entries[0].control.get("Multi-Arch"); got != "same" {
+		t.Errorf("control Multi-Arch = %q, want %q", got, "same")
+	}
+}
+
+func TestReadStatusMalformed(t *testing.T) {
+	root := t.TempDir()
+	writeTestFiles(t, root, map[string]string{"var/lib/dpkg/status": "Package: a\nbroken\n"})
+	SetOptions(Options{Root: root})
+	defer SetOptions(Options{})
+
+	entries, errs := (&dpkg{}).readStatus()
+	if entries != nil || len(errs) != 1 {
+		t.Errorf("readStatus() = %v, %v, want a single error", entries, errs)
+	}
+}
+
+func TestIsInstalled(t *testing.T) {
+	for _, tt := range []struct {
+		status string
+		want   bool
+	}{
+		{"install ok installed", true},
+		{"hold ok installed", true},
+		{"deinstall ok config-files", false},
+		{"install ok unpacked", false},
+		{"install ok half-configured", false},
+		{"", false},
+		{"installed", false},
+	} {
+		if got := (&dpkg{}).isInstalled(tt.status); got != tt.want {
+			t.Errorf("isInstalled(%q) = %v, want %v", tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestReadStatusDir(t *testing.T) {
+	root := t.TempDir()
+	writeTestFiles(t, root, map[string]string{
+		"var/lib/dpkg/status": "Package: base-files\nStatus: install ok installed\nArchitecture: amd64\nVersion: 12.4\n",
+		// Distroless images write the files without a Status field.
+		"var/lib/dpkg/status.d/tzdata":         "Package: tzdata\nArchitecture: all\nVersion: 2024a-0+deb12u1\n",
+		"var/lib/dpkg/status.d/tzdata.md5sums": "0123456789abcdef0123456789abcdef  usr/share/zoneinfo/UTC\n",
+		"var/lib/dpkg/status.d/base-files":     "Package: base-files\nArchitecture: amd64\nVersion: 12.3\n",
+		"var/lib/dpkg/status.d/removed":        "Package: removed\nStatus: deinstall ok config-files\nArchitecture: amd64\nVersion: 1.0\n",
+		"var/lib/dpkg/status.d/broken":         "broken\n",
+	})
+	SetOptions(Options{Root: root})
+	defer SetOptions(Options{})
+
+	entries, errs := (&dpkg{}).readStatus()
+	if len(errs) != 1 {
+		t.Errorf("readStatus() errors = %v, want one for the broken file", errs)
+	}
+
+	// The status database wins over status.d.
+	want := []dpkgEntrySummary{
+		{"base-files", "12.4", "amd64", "base-files", "12.4", ""},
+		{"tzdata", "2024a-0+deb12u1", "all", "tzdata", "2024a-0+deb12u1",
+			filepath.Join(root, "var", "lib", "dpkg", "status.d", "tzdata.md5sums")},
+	}
+	if got := summarizeDpkgEntries(entries); !reflect.DeepEqual(got, want) {
+		t.Errorf("readStatus() = %v, want %v", got, want)
+	}
+}
+
+func TestReadStatusDirOnly(t *testing.T) {
+	root := t.TempDir()
+	writeTestFiles(t, root, map[string]string{
+		"var/lib/dpkg/status.d/libc6": "Package: libc6\nArchitecture: amd64\nSource: glibc\nVersion: 2.36-9\n",
+	})
+	SetOptions(Options{Root: root})
+	defer SetOptions(Options{})
+
+	d := &dpkg{}
+	if !d.Available() {
+		t.Error("Available() = false with only status.d")
+	}
+	entries, errs := d.readStatus()
+	want := []dpkgEntrySummary{{"libc6", "2.36-9", "amd64", "glibc", "2.36-9", ""}}
+	if got := summarizeDpkgEntries(entries); errs != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("readStatus() = %v, %v, want %v", got, errs, want)
+	}
+}
@@ -0,0 +1,282 @@
+package pkgmanager
+
+import (
+	_ "embed"
+	"regexp"
+	"strings"
+)
+
+//go:embed licenses.txt
+var licenseTableData string
+
+//go:embed license_exceptions.txt
+var licenseExceptionTableData string
+
+// licenseTable maps lower-cased license names to SPDX expressions.
+var licenseTable = loadLicenseTable(licenseTableData)
+
+// licenseExceptionTable maps lower-cased names of license exceptions to SPDX license exception identifiers. It is only
+// used after `with`, so that an exception alone is not taken for a license.
+var licenseExceptionTable = loadLicenseTable(licenseExceptionTableData)
+
+var licenseRefInvalidRe = regexp.MustCompile(`[^A-Za-z0-9.]+`)
+
+var licenseRefRe = regexp.MustCompile(`LicenseRef-[A-Za-z0-9.-]+`)
+
+// licenseMajorVersionRe matches the version at the end of a license or license exception identifier, e.g. `2` of
+// `GPL-2.0-or-later` and of `Autoconf-exception-2.0`.
+var licenseMajorVersionRe = regexp.MustCompile(`-(\d+)(?:\.\d+)*(?:-only|-or-later|\+)?$`)
+
+func loadLicenseTable(data string) map[string]string {
+	table := make(map[string]string)
+
+	for _, line := range strings.Split(data, "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, expression, ok := strings.Cut(line, "\t")
+		if !ok {
+			expression = name
+		}
+		table[strings.ToLower(name)] = expression
+	}
+
+	return table
+}
+
+func newLicense(name string) *License {
+	return &License{
+		Name:           name,
+		SpdxExpression: NormalizeLicense(name),
+	}
+}
+
+// NormalizeLicense converts a license name or expression as written by a package manager, such as `GPL-2+` of Debian,
+// `GPLv2+ and LGPLv2+` of Fedora or `(MIT OR Apache-2.0)` of npm, into a valid SPDX license expression. Licenses that
+// have no SPDX identifier are converted into a LicenseRef derived from their name.
+func NormalizeLicense(name string) string {
+	node := parseLicense(name)
+	if node == nil {
+		return ""
+	}
+
+	return node.String()
+}
+
+// LicenseRefNames returns the license names that the LicenseRefs in the normalized form of name stand for, keyed by
+// the LicenseRefs, e.g. `Foo License` for `LicenseRef-Foo-License` in `GPLv2+ and Foo License`.
+func LicenseRefNames(name string) map[string]string {
+	names := make(map[string]string)
+
+	var walk func(*licenseNode)
+	walk = func(n *licenseNode) {
+		for _, c := range n.children {
+			walk(c)
+		}
+		for _, ref := range licenseRefRe.FindAllString(n.license, -1) {
+			names[ref] = n.name
+		}
+	}
+	if node := parseLicense(name); node != nil {
+		walk(node)
+	}
+
+	return names
+}
+
+// parseLicense parses a license name or expression, or returns nil if it is empty. A name that is known as a whole or
+// that is not a valid expression becomes a single leaf.
+func parseLicense(name string) *licenseNode {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil
+	}
+
+	if expression, ok := lookupLicense(name); ok {
+		return &licenseNode{license: expression, name: name}
+	}
+
+	p := &licenseParser{tokens: tokenizeLicense(name)}
+	node, ok := p.parseOr()
+	if !ok || p.pos != len(p.tokens) {
+		return &licenseNode{license: licenseRef(name), name: name}
+	}
+
+	return node
+}
+
+// lookupLicense returns the SPDX expression for a single license name. A trailing `+` stands for the license version or
+// any later version.
+func lookupLicense(name string) (string, bool) {
+	if expression, ok := licenseTable[strings.ToLower(name)]; ok {
+		return expression, true
+	}
+
+	base, ok := strings.CutSuffix(name, "+")
+	if !ok {
+		return "", false
+	}
+
+	expression, ok := lookupLicense(base)
+	switch {
+	case !ok || strings.ContainsAny(expression, " ()"):
+		return "", false
+	case strings.HasSuffix(expression, "-or-later"):
+		return expression, true
+	case strings.HasSuffix(expression, "-only"):
+		return strings.TrimSuffix(expression, "-only") + "-or-later", true
+	case strings.HasPrefix(expression, "LicenseRef-"):
+		return "", false
+	default:
+		return expression + "+", true
+	}
+}
+
+// resolveLicense returns the SPDX expression for a license name that may be followed by an exception, e.g.
+// `GPL-3+ with Bison exception`.
+func resolveLicense(name string) string {
+	if expression, ok := lookupLicense(name); ok {
+		return expression
+	}
+
+	if i := strings.Index(strings.ToLower(name), " with "); i != -1 {
+		license, okLicense := lookupLicense(name[:i])
+		exception, okException := resolveLicenseException(license, name[i+len(" with "):])
+		if okLicense && okException && !strings.ContainsAny(license, " ()") {
+			return license + " WITH " + exception
+		}
+	}
+
+	return licenseRef(name)
+}
+
+// resolveLicenseException returns the SPDX identifier of an exception to license. A name that stands for an exception
+// with a version for each version of the license, such as the Autoconf exception of GPL-2.0 and GPL-3.0, resolves to
+// the one with the same major version as the license, and is not found if that is ambiguous.
+func resolveLicenseException(license, name string) (string, bool) {
+	candidates := strings.Fields(licenseExceptionTable[strings.ToLower(strings.TrimSpace(name))])
+	if len(candidates) <= 1 {
+		return strings.Join(candidates, ""), len(candidates) == 1
+	}
+
+	major := licenseMajorVersionRe.FindStringSubmatch(license)
+	if major == nil {
+		return "", false
+	}
+
+	var found []string
+	for _, c := range candidates {
+		if m := licenseMajorVersionRe.FindStringSubmatch(c); m != nil && m[1] == major[1] {
+			found = append(found, c)
+		}
+	}
+	if len(found) != 1 {
+		return "", false
+	}
+
+	return found[0], true
+}
+
+func licenseRef(name string) string {
+	name = strings.ReplaceAll(name, "+", "-plus")
+	return "LicenseRef-" + strings.Trim(licenseRefInvalidRe.ReplaceAllString(name, "-"), "-")
+}
+
+// tokenizeLicense splits a license expression into parentheses and words. Commas are treated as spaces.
+func tokenizeLicense(expression string) []string {
+	expression = strings.NewReplacer("(", " ( ", ")", " ) ", ",", " ").Replace(expression)
+	return strings.Fields(expression)
+}
+
+// licenseNode is a node of a parsed license expression. A leaf has the SPDX expression of a single license in license
+// and the name it was written as in name, and other nodes join their children with op, which is either `AND` or `OR`.
+type licenseNode struct {
+	op       string
+	license  string
+	name     string
+	children []*licenseNode
+}
+
+func (n *licenseNode) String() string {
+	if n.op == "" {
+		return n.license
+	}
+
+	var operands []string
+	for _, c := range n.children {
+		s := c.String()
+		if n.op == "AND" && (c.op == "OR" || c.op == "" && strings.Contains(s, " OR ")) {
+			s = "(" + s + ")"
+		}
+		operands = append(operands, s)
+	}
+
+	return strings.Join(operands, " "+n.op+" ")
+}
+
+// licenseParser parses license expressions in which `and` binds more tightly than `or`, as in SPDX and Debian. The
+// operators are case-insensitive, and the words between them make up a license name.
+type licenseParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *licenseParser) parseOr() (*licenseNode, bool) {
+	return p.parseBinary("OR", p.parseAnd)
+}
+
+func (p *licenseParser) parseAnd() (*licenseNode, bool) {
+	return p.parseBinary("AND", p.parsePrimary)
+}
+
+func (p *licenseParser) parseBinary(op string, parseOperand func() (*licenseNode, bool)) (*licenseNode, bool) {
+	node, ok := parseOperand()
+	if !ok {
+		return nil, false
+	}
+
+	operands := []*licenseNode{node}
+	for p.pos < len(p.tokens) && strings.EqualFold(p.tokens[p.pos], op) {
+		p.pos++
+		node, ok := parseOperand()
+		if !ok {
+			return nil, false
+		}
+		operands = append(operands, node)
+	}
+
+	if len(operands) == 1 {
+		return operands[0], true
+	}
+
+	return &licenseNode{op: op, children: operands}, true
+}
+
+func (p *licenseParser) parsePrimary() (*licenseNode, bool) {
+	if p.pos < len(p.tokens) && p.tokens[p.pos] == "(" {
+		p.pos++
+		node, ok := p.parseOr()
+		if !ok || p.pos == len(p.tokens) || p.tokens[p.pos] != ")" {
+			return nil, false
+		}
+		p.pos++
+		return node, true
+	}
+
+	var words []string
+	for ; p.pos < len(p.tokens); p.pos++ {
+		t := p.tokens[p.pos]
+		if t == "(" || t == ")" || strings.EqualFold(t, "and") || strings.EqualFold(t, "or") {
+			break
+		}
+		words = append(words, t)
+	}
+
+	if len(words) == 0 {
+		return nil, false
+	}
+
+	name := strings.Join(words, " ")
+	return &licenseNode{license: resolveLicense(name), name: name}, true
+}
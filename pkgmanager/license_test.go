@@ -0,0 +1,111 @@
+package pkgmanager
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeLicense(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		want string
+	}{
+		{"", ""},
+		{"  ", ""},
+
+		// SPDX identifiers, case-insensitively, and deprecated ones.
+		{"MIT", "MIT"},
+		{"apache-2.0", "Apache-2.0"},
+		{"GPL-2.0", "GPL-2.0-only"},
+		{"GPL-2.0-with-autoconf-exception", "GPL-2.0-only WITH Autoconf-exception-2.0"},
+
+		// Debian names, with `+` for later versions.
+		{"GPL-2", "GPL-2.0-only"},
+		{"GPL-2+", "GPL-2.0-or-later"},
+		{"LGPL-2.1+", "LGPL-2.1-or-later"},
+		{"GPL+", "GPL-1.0-or-later"},
+		{"Apache-2.0+", "Apache-2.0+"},
+		{"Expat", "MIT"},
+		{"Perl", "Artistic-1.0-Perl OR GPL-1.0-or-later"},
+		{"public-domain", "LicenseRef-public-domain"},
+
+		// Fedora names, which contain spaces.
+		{"ASL 2.0", "Apache-2.0"},
+		{"GPLv2+", "GPL-2.0-or-later"},
+		{"GPLv2+ and LGPLv2+", "GPL-2.0-or-later AND LGPL-2.0-or-later"},
+		{"BSD with advertising", "BSD-4-Clause"},
+		{"Public Domain", "LicenseRef-public-domain"},
+
+		// npm names and expressions.
+		{"(MIT OR Apache-2.0)", "MIT OR Apache-2.0"},
+		{"Apache License, Version 2.0", "Apache-2.0"},
+		{"The MIT License", "MIT"},
+
+		// `and` binds more tightly than `or`, and parentheses are kept where they are needed.
+		{"MIT or ISC and BSD-3-clause", "MIT OR ISC AND BSD-3-Clause"},
+		{"(MIT or ISC) and BSD-3-clause", "(MIT OR ISC) AND BSD-3-Clause"},
+		{"((MIT))", "MIT"},
+		{"Perl and MIT", "(Artistic-1.0-Perl OR GPL-1.0-or-later) AND MIT"},
+
+		// Exceptions.
+		{"GPL-3+ with Bison exception", "GPL-3.0-or-later WITH Bison-exception-2.2"},
+		{"GPL-2+ with Autoconf exception", "GPL-2.0-or-later WITH Autoconf-exception-2.0"},
+		{"GPL-3+ with Autoconf exception", "GPL-3.0-or-later WITH Autoconf-exception-3.0"},
+		{"GPLv3+ with exceptions", "LicenseRef-GPLv3-plus-with-exceptions"},
+		{"GPL with GCC exception", "LicenseRef-GPL-with-GCC-exception"},
+		{"Perl with Bison exception", "LicenseRef-Perl-with-Bison-exception"},
+
+		// Unknown names and invalid expressions.
+		{"Foo License", "LicenseRef-Foo-License"},
+		{"Foo+", "LicenseRef-Foo-plus"},
+		{"GPLv2+ and Foo License", "GPL-2.0-or-later AND LicenseRef-Foo-License"},
+		{"(MIT", "LicenseRef-MIT"},
+		{"MIT and", "LicenseRef-MIT-and"},
+	} {
+		if got := NormalizeLicense(tt.name); got != tt.want {
+			t.Errorf("NormalizeLicense(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestResolveLicenseException(t *testing.T) {
+	for _, tt := range []struct {
+		license, name string
+		want          string
+		wantOK        bool
+	}{
+		{"GPL-3.0-or-later", "Bison exception", "Bison-exception-2.2", true},
+		{"GPL-2.0-only", "autoconf exception", "Autoconf-exception-2.0", true},
+		{"GPL-3.0-only", "GCC exception", "GCC-exception-3.1", true},
+		{"GPL-3.0+", "Autoconf exception", "Autoconf-exception-3.0", true},
+		// There is no Autoconf exception for version 1.
+		{"GPL-1.0-or-later", "Autoconf exception", "", false},
+		{"MIT", "Autoconf exception", "", false},
+		{"GPL-2.0-only", "Unknown exception", "", false},
+	} {
+		got, ok := resolveLicenseException(tt.license, tt.name)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("resolveLicenseException(%q, %q) = %q, %v, want %q, %v", tt.license, tt.name, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestLicenseRefNames(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		want map[string]string
+	}{
+		{"MIT", map[string]string{}},
+		{"", map[string]string{}},
+		{"GPLv2+ and Foo License", map[string]string{"LicenseRef-Foo-License": "Foo License"}},
+		{"Public Domain or (Bar and MIT)", map[string]string{
+			"LicenseRef-public-domain": "Public Domain",
+			"LicenseRef-Bar":           "Bar",
+		}},
+		{"(broken", map[string]string{"LicenseRef-broken": "(broken"}},
+	} {
+		if got := LicenseRefNames(tt.name); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("LicenseRefNames(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
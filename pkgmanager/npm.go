@@ -1,96 +1,131 @@
 package pkgmanager
 
 import (
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"github.com/Hitachi/spirat/utils"
 	"github.com/package-url/packageurl-go"
 	"io"
 	"os"
-	"os/exec"
-	"path"
+	"path/filepath"
+	"sort"
 	"strings"
 )
 
 type npm struct{}
 
-type npmList struct {
-	Dependencies map[string]*dependency `json:"dependencies"`
-}
+// licenseFilePrefixes are the lower-cased prefixes of the names of the files that packages ship their license texts and
+// notices in, such as LICENSE.md, LICENCE-MIT and NOTICE.
+var licenseFilePrefixes = []string{"license", "licence", "copying", "notice"}
 
-type dependency struct {
-	Name         string                 `json:"name"`
-	Version      string                 `json:"version"`
-	Description  string                 `json:"description"`
-	Homepage     string                 `json:"homepage"`
-	License      interface{}            `json:"license"`
-	Licenses     interface{}            `json:"licenses"`
-	Author       interface{}            `json:"author"`
-	Repository   interface{}            `json:"repository"`
-	Resolved     string                 `json:"resolved"`
-	Path         string                 `json:"path"`
-	Dependencies map[string]*dependency `json:"dependencies"`
-}
+// seeLicenseInPrefix is how package.json refers to a file in the package for a license without an SPDX identifier.
+const seeLicenseInPrefix = "SEE LICENSE IN "
 
-type author struct {
-	Name  string `json:"name"`
-	Email string `json:"email"`
+// dependency is a package installed in node_modules as recorded in the lockfile.
+type dependency struct {
+	Name        string
+	Version     string
+	Description string
+	Homepage    string
+	License     interface{}
+	Licenses    interface{}
+	Repository  interface{}
+	// LicenseFiles are the license files found in Path.
+	LicenseFiles []*LicenseFile
+	Resolved     string
+	// Checksums are the checksums of the tarball in Resolved.
+	Checksums []*Checksum
+	// Path is the directory of the package, e.g. `node_modules/foo/node_modules/bar`.
+	Path string
+	// Dependencies are the packages that the package loads keyed by the names it requires them with.
+	Dependencies map[string]*dependency
+	// DependencyTypes are the types of the dependencies that are not DependencyTypeDependsOn.
+	DependencyTypes map[string]DependencyType
+	// Dev marks a package that is only needed to develop the project.
+	Dev bool
+	// Project marks the project or one of its workspaces, which have no tarball.
+	Project bool
 }
 
-type repository struct {
-	Type string `json:"type"`
-	Url  string `json:"url"`
+// addDependency records that the package requires found with name. A later type replaces an earlier one, as
+// optionalDependencies in package.json override dependencies.
+func (d *dependency) addDependency(name string, found *dependency, typ DependencyType) {
+	d.Dependencies[name] = found
+	if typ == DependencyTypeDependsOn {
+		delete(d.DependencyTypes, name)
+		return
+	}
+	if d.DependencyTypes == nil {
+		d.DependencyTypes = make(map[string]DependencyType)
+	}
+	d.DependencyTypes[name] = typ
 }
 
-type license struct {
-	Type string `json:"type"`
-	Url  string `json:"url"`
+func (d *dependency) dependencyType(name string) DependencyType {
+	if typ, ok := d.DependencyTypes[name]; ok {
+		return typ
+	}
+	return DependencyTypeDependsOn
 }
 
+// packageLicenses returns the license of the package. `license` holds an SPDX expression such as `(MIT OR Apache-2.0)`,
+// `UNLICENSED` or `SEE LICENSE IN <file>`, and old packages write a `{"type": ..., "url": ...}` object there or in a
+// `licenses` array instead. The entries of `licenses` are alternatives, so they are joined with OR.
 func (d *dependency) packageLicenses() []*License {
-	if ls, ok := d.Licenses.([]license); ok {
-		return utils.Map(ls, func(l license) *License {
-			return &License{l.Type}
-		})
+	names := licenseNames(d.License)
+	if len(names) == 0 {
+		names = licenseNames(d.Licenses)
 	}
 
-	if ls, ok := d.Licenses.([]string); ok {
-		return utils.Map(ls, func(l string) *License {
-			return &License{l}
-		})
+	switch len(names) {
+	case 0:
+		return []*License{}
+	case 1:
+		return []*License{newLicense(names[0])}
 	}
 
-	if l, ok := d.License.(license); ok {
-		return []*License{{l.Type}}
+	for i, name := range names {
+		if strings.Contains(name, " ") {
+			names[i] = "(" + name + ")"
+		}
 	}
+	return []*License{newLicense(strings.Join(names, " OR "))}
+}
 
-	if s, ok := d.License.(string); ok {
-		return []*License{{s}}
+// licenseNames returns the licenses in `license` or `licenses` of package.json as decoded by encoding/json, which may
+// be a string, an object with the name in `type`, or an array of either.
+func licenseNames(v interface{}) []string {
+	switch l := v.(type) {
+	case string:
+		if l = strings.TrimSpace(l); l != "" {
+			return []string{l}
+		}
+	case map[string]interface{}:
+		// Some packages write `name` instead of `type`.
+		for _, key := range []string{"type", "name"} {
+			if s, ok := l[key].(string); ok && strings.TrimSpace(s) != "" {
+				return []string{strings.TrimSpace(s)}
+			}
+		}
+	case []interface{}:
+		var names []string
+		for _, e := range l {
+			names = append(names, licenseNames(e)...)
+		}
+		return names
 	}
 
-	return []*License{}
+	return nil
 }
 
 func (n *npm) Query() (*QueryResult, []error) {
-	cmd := exec.Command("npm", "list", "--json", "--all", "--long", "--package-lock-only", "--unicode")
-	output, err := cmd.Output()
-	if err != nil {
-		return nil, []error{fmt.Errorf("%s: %w", string(output), err)}
-	}
-
-	var list npmList
-	err = json.Unmarshal(output, &list)
-	if err != nil {
-		return nil, []error{err}
-	}
-
-	queryResult := &QueryResult{Packages: make(map[PackageID]*Package)}
-	n.fillInformationUsingPackageJson(list.Dependencies)
-	for _, dep := range list.Dependencies {
-		n.addPackage(queryResult, dep)
-	}
+	return n.queryProject(projectPath())
+}
 
-	return queryResult, nil
+func (n *npm) QueryProjects() (map[string]*QueryResult, []error) {
+	return queryProjects(npmLockfileNames, n.queryProject)
 }
 
 func (n *npm) String() string {
@@ -98,29 +133,64 @@ func (n *npm) String() string {
 }
 
 func (n *npm) Available() bool {
-	if _, err := os.Stat("package-lock.json"); os.IsNotExist(err) {
-		if _, err := os.Stat("npm-shrinkwrap.json"); os.IsNotExist(err) {
-			return false
+	return len(findProjects(npmLockfileNames)) > 0
+}
+
+// queryProject returns the packages of the project in dir.
+func (n *npm) queryProject(dir string) (*QueryResult, []error) {
+	lockfile, err := n.readLockfile(dir)
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	return n.newQueryResult(n.resolveLockfile(dir, lockfile))
+}
+
+// newQueryResult returns the result of the packages of a project, whose projects are the roots. Packages not needed in
+// production are left out if OmitDev is set.
+func (n *npm) newQueryResult(deps []*dependency) (*QueryResult, []error) {
+	deps = n.omitDevDependencies(deps)
+	errs := n.fillInformationUsingPackageJson(deps)
+
+	queryResult := &QueryResult{Packages: make(map[PackageID]*Package)}
+	seen := make(map[PackageDependency]struct{})
+	roots := make(map[PackageID]struct{})
+	for _, dep := range deps {
+		pkg := n.addPackage(queryResult, dep, seen)
+		if _, ok := roots[pkg.ID]; dep.Project && !ok {
+			roots[pkg.ID] = struct{}{}
+			queryResult.Roots = append(queryResult.Roots, pkg.ID)
 		}
 	}
 
-	return hasCommand("npm")
+	return queryResult, errs
 }
 
-func (n *npm) addPackage(queryResult *QueryResult, dep *dependency) *Package {
+// addPackage adds a package and its dependencies unless they are in seen. The same version installed in several places
+// may depend on different versions of a package, depending on what is installed around it.
+func (n *npm) addPackage(queryResult *QueryResult, dep *dependency, seen map[PackageDependency]struct{}) *Package {
 	namespace, name := n.splitToNamespaceAndName(dep.Name)
 
 	if queryResult.Packages[packageID(dep.Name, dep.Version)] == nil {
+		licenseFiles := dep.LicenseFiles
+		if licenseFiles == nil {
+			licenseFiles = []*LicenseFile{}
+		}
+		filename := fmt.Sprintf("%s-%s.tgz", dep.Name, dep.Version)
+		if dep.Project {
+			filename = ""
+		}
 		queryResult.Packages[packageID(dep.Name, dep.Version)] = &Package{
 			ID:           packageID(dep.Name, dep.Version),
 			Name:         name,
 			Namespace:    namespace,
 			Version:      dep.Version,
 			Licenses:     dep.packageLicenses(),
-			LicenseFiles: []*LicenseFile{},
+			LicenseFiles: licenseFiles,
 			HomepageUrl:  dep.Homepage,
 			DownloadUrl:  dep.Resolved,
-			Filename:     fmt.Sprintf("%s-%s.tgz", dep.Name, dep.Version),
+			Checksums:    dep.Checksums,
+			Filename:     filename,
 			PackageURL: packageurl.NewPackageURL(
 				packageurl.TypeNPM,
 				namespace,
@@ -132,19 +202,127 @@ func (n *npm) addPackage(queryResult *QueryResult, dep *dependency) *Package {
 		}
 	}
 
-	pkg := queryResult.Packages[packageID(dep.Name, dep.Version)]
+	names := make([]string, 0, len(dep.Dependencies))
+	for name := range dep.Dependencies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
-	for _, dd := range dep.Dependencies {
-		queryResult.Dependencies = append(queryResult.Dependencies, &PackageDependency{
+	for _, name := range names {
+		dd := dep.Dependencies[name]
+		d := PackageDependency{
 			RequiringPackageID: packageID(dep.Name, dep.Version),
 			RequiredPackageID:  packageID(dd.Name, dd.Version),
-			DependencyType:     "DEPENDS_ON",
-		})
+			DependencyType:     dep.dependencyType(name),
+		}
+		if _, ok := seen[d]; !ok {
+			seen[d] = struct{}{}
+			queryResult.Dependencies = append(queryResult.Dependencies, &d)
+		}
+	}
+
+	return queryResult.Packages[packageID(dep.Name, dep.Version)]
+}
 
-		n.addPackage(queryResult, dd)
+// projectDependency returns the project or the workspace in dir, which is named after the directory if package.json does
+// not name it.
+func (n *npm) projectDependency(dir string) *dependency {
+	d := &dependency{
+		Path:         dir,
+		Project:      true,
+		Dependencies: make(map[string]*dependency),
+	}
+	if pj, err := n.parsePackageJson(filepath.Join(dir, "package.json")); err == nil {
+		d.Name, d.Version = pj.Name, pj.Version
+	}
+	if d.Name == "" {
+		if abs, err := filepath.Abs(dir); err == nil {
+			d.Name = filepath.Base(abs)
+		}
 	}
 
-	return pkg
+	return d
+}
+
+// markDevDependencies marks the packages that are not reachable from prod as Dev. The dependencies of a package are
+// followed unless they are dev dependencies.
+func (n *npm) markDevDependencies(deps []*dependency, prod []*dependency) {
+	reachable := make(map[*dependency]struct{})
+	var visit func(d *dependency)
+	visit = func(d *dependency) {
+		if _, ok := reachable[d]; ok {
+			return
+		}
+		reachable[d] = struct{}{}
+		for name, dd := range d.Dependencies {
+			if d.dependencyType(name) != DependencyTypeDev {
+				visit(dd)
+			}
+		}
+	}
+	for _, d := range prod {
+		visit(d)
+	}
+
+	for _, d := range deps {
+		_, ok := reachable[d]
+		d.Dev = !ok
+	}
+}
+
+// omitDevDependencies leaves out the packages marked Dev and the dev dependencies of the rest if OmitDev is set.
+func (n *npm) omitDevDependencies(deps []*dependency) []*dependency {
+	if !options.OmitDev {
+		return deps
+	}
+
+	ret := make([]*dependency, 0, len(deps))
+	for _, d := range deps {
+		if d.Dev {
+			continue
+		}
+		for name, dd := range d.Dependencies {
+			if dd.Dev || d.dependencyType(name) == DependencyTypeDev {
+				delete(d.Dependencies, name)
+			}
+		}
+		ret = append(ret, d)
+	}
+
+	return ret
+}
+
+// integrityChecksums decodes a Subresource Integrity string such as `sha512-<base64>`, which may list several hashes
+// separated by spaces. Hashes of unknown algorithms are skipped.
+func (n *npm) integrityChecksums(integrity string) []*Checksum {
+	var ret []*Checksum
+	for _, hash := range strings.Fields(integrity) {
+		algo, digest, _ := strings.Cut(hash, "-")
+		// Options may follow the digest after a question mark.
+		digest, _, _ = strings.Cut(digest, "?")
+
+		var algorithm ChecksumAlgorithm
+		switch algo {
+		case "sha1":
+			algorithm = ChecksumSHA1
+		case "sha256":
+			algorithm = ChecksumSHA256
+		case "sha384":
+			algorithm = ChecksumSHA384
+		case "sha512":
+			algorithm = ChecksumSHA512
+		default:
+			continue
+		}
+
+		bytes, err := base64.StdEncoding.DecodeString(digest)
+		if err != nil {
+			continue
+		}
+		ret = append(ret, &Checksum{Algorithm: algorithm, Value: hex.EncodeToString(bytes)})
+	}
+
+	return ret
 }
 
 func (n *npm) splitToNamespaceAndName(namespaceAndName string) (string, string) {
@@ -156,28 +334,129 @@ func (n *npm) splitToNamespaceAndName(namespaceAndName string) (string, string)
 	}
 }
 
-func (n *npm) fillInformationUsingPackageJson(deps map[string]*dependency) {
+// fillInformationUsingPackageJson fills in the information that the lockfile lacks from the package.json and the license
+// files of the packages that are installed. The lockfile of an install that has not run yet is used as is.
+func (n *npm) fillInformationUsingPackageJson(deps []*dependency) []error {
+	var errs []error
 	for _, dep := range deps {
-		p := path.Join(dep.Path, "package.json")
+		if dep.Path == "" {
+			continue
+		}
+
+		p := filepath.Join(dep.Path, "package.json")
 		pj, err := n.parsePackageJson(p)
 		if err != nil {
-			return
+			continue
 		}
 
-		dep.License = pj.License
-		dep.Licenses = pj.Licenses
+		// The license recorded in the lockfile is kept unless package.json has one.
+		if len(licenseNames(pj.License)) > 0 || len(licenseNames(pj.Licenses)) > 0 {
+			dep.License = pj.License
+			dep.Licenses = pj.Licenses
+		}
 		dep.Description = pj.Description
 		dep.Repository = pj.Repository
 
-		n.fillInformationUsingPackageJson(dep.Dependencies)
+		dep.LicenseFiles, err = n.readLicenseFiles(dep.Path, dep.License)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to read license files of %s: %v", dep.Name, err))
+		}
 	}
+
+	return errs
+}
+
+// readLicenseFiles reads the license files at the top of the package in dir along with the file that license refers to
+// as in `SEE LICENSE IN LICENSE.txt`, which may have any name.
+func (n *npm) readLicenseFiles(dir string, license interface{}) ([]*LicenseFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]struct{})
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		lower := strings.ToLower(e.Name())
+		for _, prefix := range licenseFilePrefixes {
+			if strings.HasPrefix(lower, prefix) {
+				names[e.Name()] = struct{}{}
+				break
+			}
+		}
+	}
+
+	if s, ok := license.(string); ok && len(s) > len(seeLicenseInPrefix) &&
+		strings.EqualFold(s[:len(seeLicenseInPrefix)], seeLicenseInPrefix) {
+		name := filepath.Clean(filepath.FromSlash(strings.TrimSpace(s[len(seeLicenseInPrefix):])))
+		// The file may also be a URL, which is left to the license itself.
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil && filepath.IsLocal(name) {
+			names[name] = struct{}{}
+		}
+	}
+
+	licenseFiles := []*LicenseFile{}
+	for _, name := range utils.SortedKeys(names) {
+		p := filepath.Join(dir, name)
+		bytes, err := os.ReadFile(p)
+		if err != nil {
+			return licenseFiles, err
+		}
+		licenseFiles = append(licenseFiles, &LicenseFile{
+			Path:    p,
+			Content: string(bytes),
+		})
+	}
+
+	return licenseFiles, nil
 }
 
 type packageJson struct {
-	License     interface{} `json:"license"`
-	Licenses    interface{} `json:"licenses"`
-	Description string      `json:"description"`
-	Repository  interface{} `json:"repository"`
+	Name                 string            `json:"name"`
+	Version              string            `json:"version"`
+	License              interface{}       `json:"license"`
+	Licenses             interface{}       `json:"licenses"`
+	Description          string            `json:"description"`
+	Repository           interface{}       `json:"repository"`
+	Dependencies         map[string]string `json:"dependencies"`
+	DevDependencies      map[string]string `json:"devDependencies"`
+	OptionalDependencies map[string]string `json:"optionalDependencies"`
+	PeerDependencies     map[string]string `json:"peerDependencies"`
+	// Workspaces are the patterns of the directories of the workspaces, either as an array or in `packages` of an
+	// object as Yarn Classic allows.
+	Workspaces interface{} `json:"workspaces"`
+}
+
+// workspaces returns the directories that match the patterns in Workspaces relative to dir.
+func (pj *packageJson) workspaces(dir string) []string {
+	var patterns []interface{}
+	switch w := pj.Workspaces.(type) {
+	case []interface{}:
+		patterns = w
+	case map[string]interface{}:
+		patterns, _ = w["packages"].([]interface{})
+	}
+
+	var dirs []string
+	for _, pattern := range patterns {
+		s, ok := pattern.(string)
+		if !ok {
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(dir, filepath.FromSlash(s)))
+		if err != nil {
+			continue
+		}
+		for _, m := range matches {
+			if _, err := os.Stat(filepath.Join(m, "package.json")); err == nil {
+				dirs = append(dirs, m)
+			}
+		}
+	}
+
+	return dirs
 }
 
 func (n *npm) parsePackageJson(path string) (*packageJson, error) {
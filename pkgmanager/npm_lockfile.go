@@ -0,0 +1,272 @@
+package pkgmanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// npmLockfileNames are the lockfiles of npm in order of precedence. npm-shrinkwrap.json is the publishable form of
+// package-lock.json, and npm ignores package-lock.json when both exist.
+var npmLockfileNames = []string{"npm-shrinkwrap.json", "package-lock.json"}
+
+// npmLockfile is package-lock.json or npm-shrinkwrap.json. Version 1 describes the node_modules tree in nested
+// `dependencies`, version 3 in `packages` keyed by the path of each package, and version 2 has both.
+type npmLockfile struct {
+	Name            string                        `json:"name"`
+	Version         string                        `json:"version"`
+	LockfileVersion int                           `json:"lockfileVersion"`
+	Packages        map[string]*npmLockPackage    `json:"packages"`
+	Dependencies    map[string]*npmLockDependency `json:"dependencies"`
+}
+
+// npmLockPackage is an entry of `packages`. The entry with the empty key is the project itself.
+type npmLockPackage struct {
+	// Name is only present if it differs from the directory, such as for aliases and the project itself.
+	Name      string      `json:"name"`
+	Version   string      `json:"version"`
+	Resolved  string      `json:"resolved"`
+	Integrity string      `json:"integrity"`
+	License   interface{} `json:"license"`
+	// Link marks a symbolic link to the package in Resolved, which is another key of `packages`, such as a workspace.
+	Link bool `json:"link"`
+	// Dev marks a package that is only needed by devDependencies of the project, and DevOptional one that is needed by
+	// both devDependencies and optionalDependencies, which npm keeps unless both are omitted.
+	Dev         bool `json:"dev"`
+	DevOptional bool `json:"devOptional"`
+	// InBundle marks a package that is shipped inside the tarball of a package that bundles it.
+	InBundle             bool              `json:"inBundle"`
+	Dependencies         map[string]string `json:"dependencies"`
+	DevDependencies      map[string]string `json:"devDependencies"`
+	OptionalDependencies map[string]string `json:"optionalDependencies"`
+	PeerDependencies     map[string]string `json:"peerDependencies"`
+}
+
+// npmLockDependency is an entry of `dependencies` in version 1, keyed by the directory of the package.
+type npmLockDependency struct {
+	// Version is the version, `npm:<name>@<version>` for an alias or a `file:` URL for a link.
+	Version   string `json:"version"`
+	Resolved  string `json:"resolved"`
+	Integrity string `json:"integrity"`
+	Dev       bool   `json:"dev"`
+	Bundled   bool   `json:"bundled"`
+	// Requires are the dependencies of the package, which are found in its own nested Dependencies or in those of an
+	// ancestor as in node_modules.
+	Requires     map[string]string             `json:"requires"`
+	Dependencies map[string]*npmLockDependency `json:"dependencies"`
+}
+
+// readLockfile reads the first lockfile in npmLockfileNames that exists in dir.
+func (n *npm) readLockfile(dir string) (*npmLockfile, error) {
+	for _, name := range npmLockfileNames {
+		bytes, err := os.ReadFile(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		lockfile := &npmLockfile{}
+		if err := json.Unmarshal(bytes, lockfile); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", name, err)
+		}
+		if lockfile.Packages == nil {
+			lockfile.Packages = make(map[string]*npmLockPackage)
+			n.flattenLockDependencies(dir, "", lockfile.Dependencies, lockfile.Packages)
+		}
+		if _, ok := lockfile.Packages[""]; !ok {
+			// Version 1 has no entry of the project, whose dependencies are only in package.json.
+			project := &npmLockPackage{Name: lockfile.Name, Version: lockfile.Version}
+			if pj, err := n.parsePackageJson(filepath.Join(dir, "package.json")); err == nil {
+				project.Dependencies = pj.Dependencies
+				project.DevDependencies = pj.DevDependencies
+				project.OptionalDependencies = pj.OptionalDependencies
+				project.PeerDependencies = pj.PeerDependencies
+			}
+			lockfile.Packages[""] = project
+		}
+
+		return lockfile, nil
+	}
+
+	return nil, fmt.Errorf("no lockfile found in %s", dir)
+}
+
+// flattenLockDependencies converts the nested `dependencies` of version 1 into `packages` of version 3. A link becomes
+// an entry with Link set and an entry of its target, which version 1 does not have, named and versioned after the
+// package.json in the target under dir.
+func (n *npm) flattenLockDependencies(dir, parent string, deps map[string]*npmLockDependency, packages map[string]*npmLockPackage) {
+	for name, d := range deps {
+		key := path.Join(parent, "node_modules", name)
+		if target, ok := n.lockLinkTarget(d.Version); ok {
+			packages[key] = &npmLockPackage{Link: true, Resolved: target, Dev: d.Dev}
+			if _, ok := packages[target]; !ok {
+				p := &npmLockPackage{Name: name, Dev: d.Dev, Dependencies: d.Requires}
+				if pj, err := n.parsePackageJson(filepath.Join(dir, filepath.FromSlash(target), "package.json")); err == nil {
+					if pj.Name != "" {
+						p.Name = pj.Name
+					}
+					p.Version = pj.Version
+				}
+				packages[target] = p
+			}
+			// The dependencies of a link are installed in the node_modules of its target.
+			n.flattenLockDependencies(dir, target, d.Dependencies, packages)
+			continue
+		}
+
+		p := &npmLockPackage{
+			Version:      d.Version,
+			Resolved:     d.Resolved,
+			Integrity:    d.Integrity,
+			Dev:          d.Dev,
+			InBundle:     d.Bundled,
+			Dependencies: d.Requires,
+		}
+		if spec, ok := strings.CutPrefix(d.Version, "npm:"); ok {
+			// The version of an alias is preceded by the name, which may start with the @ of a scope.
+			if i := strings.LastIndex(spec, "@"); i > 0 {
+				p.Name, p.Version = spec[:i], spec[i+1:]
+			}
+		}
+		packages[key] = p
+
+		n.flattenLockDependencies(dir, key, d.Dependencies, packages)
+	}
+}
+
+// lockLinkTarget returns the key of the directory that a `file:` version of version 1 links to, e.g. `packages/foo` for
+// `file:packages/foo`, which is relative to the project. Tarballs are installed rather than linked.
+func (n *npm) lockLinkTarget(version string) (string, bool) {
+	target, ok := strings.CutPrefix(version, "file:")
+	if !ok {
+		return "", false
+	}
+	for _, ext := range []string{".tgz", ".tar.gz", ".tar"} {
+		if strings.HasSuffix(target, ext) {
+			return "", false
+		}
+	}
+
+	return path.Clean(target), true
+}
+
+// resolveLockfile returns the project in dir, its workspaces and the packages installed in its node_modules with their
+// dependencies resolved, leaving out links.
+func (n *npm) resolveLockfile(dir string, lockfile *npmLockfile) []*dependency {
+	deps := make(map[string]*dependency)
+	for key, p := range lockfile.Packages {
+		if p.Link {
+			continue
+		}
+		if key == "" {
+			root := n.projectDependency(dir)
+			// The lockfile names the project even if package.json is gone.
+			if p.Name != "" {
+				root.Name, root.Version = p.Name, p.Version
+			}
+			root.License = p.License
+			deps[key] = root
+			continue
+		}
+
+		name := p.Name
+		if name == "" {
+			name = n.nameFromKey(key)
+		}
+		deps[key] = &dependency{
+			Name:         name,
+			Version:      p.Version,
+			License:      p.License,
+			Resolved:     p.Resolved,
+			Checksums:    n.integrityChecksums(p.Integrity),
+			Path:         filepath.Join(dir, filepath.FromSlash(key)),
+			Dependencies: make(map[string]*dependency),
+			Dev:          p.Dev,
+			// Workspaces are outside node_modules.
+			Project: !strings.Contains(key, "node_modules/"),
+		}
+	}
+
+	keys := make([]string, 0, len(deps))
+	for key := range deps {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	ret := make([]*dependency, 0, len(keys))
+	for _, key := range keys {
+		d := deps[key]
+		p := lockfile.Packages[key]
+		// Only the project and workspaces have devDependencies in the lockfile.
+		for _, required := range []struct {
+			names map[string]string
+			typ   DependencyType
+		}{
+			{p.DevDependencies, DependencyTypeDev},
+			{p.PeerDependencies, DependencyTypePeer},
+			{p.Dependencies, DependencyTypeDependsOn},
+			{p.OptionalDependencies, DependencyTypeOptional},
+		} {
+			for name := range required.names {
+				// Optional dependencies and peer dependencies may be missing. The empty key of the project is never the
+				// package that a name resolves to.
+				found := n.resolveLockPackage(lockfile, key, name)
+				dd, ok := deps[found]
+				if found == "" || !ok {
+					continue
+				}
+				typ := required.typ
+				if lockfile.Packages[found].InBundle && strings.HasPrefix(found, key+"/node_modules/") {
+					typ = DependencyTypeBundled
+				}
+				d.addDependency(name, dd, typ)
+			}
+		}
+		ret = append(ret, d)
+	}
+
+	return ret
+}
+
+// resolveLockPackage returns the key of the package that `require(name)` loads from the package at key, looking in the
+// node_modules of the package and then in those of its ancestors like Node.js does. Links are followed to their
+// targets. It returns an empty string if there is no such package.
+func (n *npm) resolveLockPackage(lockfile *npmLockfile, key, name string) string {
+	for dir := key; ; {
+		found := path.Join(dir, "node_modules", name)
+		if p, ok := lockfile.Packages[found]; ok {
+			if p.Link {
+				if _, ok := lockfile.Packages[p.Resolved]; !ok {
+					return ""
+				}
+				return p.Resolved
+			}
+			return found
+		}
+
+		if dir == "" {
+			return ""
+		}
+		if i := strings.LastIndex(dir, "/node_modules/"); i >= 0 {
+			dir = dir[:i]
+		} else {
+			dir = ""
+		}
+	}
+}
+
+// nameFromKey returns the name of the package at key from its directory, e.g. `@babel/core` for
+// `node_modules/foo/node_modules/@babel/core`. Packages outside node_modules such as workspaces are named after their
+// directory.
+func (n *npm) nameFromKey(key string) string {
+	if i := strings.LastIndex(key, "node_modules/"); i >= 0 {
+		return key[i+len("node_modules/"):]
+	}
+	return path.Base(key)
+}
@@ -0,0 +1,245 @@
+package pkgmanager
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// dependencySummary is what the tests of the lockfile readers compare of a dependency. Each of deps is the name it is
+// required with, the name and version of the package it resolves to and the type if it is not DEPENDS_ON.
+type dependencySummary struct {
+	id           string
+	dev, project bool
+	deps         []string
+}
+
+func summarizeDependencies(deps []*dependency) []dependencySummary {
+	var summaries []dependencySummary
+	for _, d := range deps {
+		s := dependencySummary{id: d.Name + "@" + d.Version, dev: d.Dev, project: d.Project}
+		for name, found := range d.Dependencies {
+			dep := name + "=" + found.Name + "@" + found.Version
+			if typ, ok := d.DependencyTypes[name]; ok {
+				dep += " " + string(typ)
+			}
+			s.deps = append(s.deps, dep)
+		}
+		sort.Strings(s.deps)
+		summaries = append(summaries, s)
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].id < summaries[j].id })
+
+	return summaries
+}
+
+func resolveNpmTestLockfile(t *testing.T, dir string) []*dependency {
+	t.Helper()
+	n := &npm{}
+	lockfile, err := n.readLockfile(dir)
+	if err != nil {
+		t.Fatalf("readLockfile() error = %v", err)
+	}
+	return n.resolveLockfile(dir, lockfile)
+}
+
+func TestNpmLockfileV1(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"package.json": `{
+			"name": "app",
+			"version": "1.0.0",
+			"dependencies": {"a": "^1.0.0", "c": "npm:@scope/real@^3.0.0", "local": "file:packages/local"},
+			"devDependencies": {"d": "^1.0.0"}
+		}`,
+		"packages/local/package.json": `{"name": "@app/local", "version": "0.1.0"}`,
+		"package-lock.json": `{
+			"name": "app",
+			"version": "1.0.0",
+			"lockfileVersion": 1,
+			"requires": true,
+			"dependencies": {
+				"a": {
+					"version": "1.0.0",
+					"resolved": "https://registry.npmjs.org/a/-/a-1.0.0.tgz",
+					"integrity": "sha1-AAAAAAAAAAAAAAAAAAAAAAAAAAA=",
+					"requires": {"b": "^2.0.0"},
+					"dependencies": {"b": {"version": "2.0.0"}}
+				},
+				"b": {"version": "1.0.0"},
+				"c": {"version": "npm:@scope/real@3.0.0", "requires": {"b": "^1.0.0"}},
+				"d": {"version": "1.0.0", "dev": true},
+				"local": {
+					"version": "file:packages/local",
+					"requires": {"b": "^1.0.0", "e": "^1.0.0"},
+					"dependencies": {"e": {"version": "1.0.0"}}
+				},
+				"tarball": {"version": "file:vendor/tarball-1.0.0.tgz"}
+			}
+		}`,
+	})
+
+	deps := resolveNpmTestLockfile(t, dir)
+	want := []dependencySummary{
+		{id: "@app/local@0.1.0", project: true, deps: []string{"b=b@1.0.0", "e=e@1.0.0"}},
+		{id: "@scope/real@3.0.0", deps: []string{"b=b@1.0.0"}},
+		{id: "a@1.0.0", deps: []string{"b=b@2.0.0"}},
+		{id: "app@1.0.0", project: true, deps: []string{
+			"a=a@1.0.0", "c=@scope/real@3.0.0", "d=d@1.0.0 DEV", "local=@app/local@0.1.0",
+		}},
+		{id: "b@1.0.0"},
+		{id: "b@2.0.0"},
+		{id: "d@1.0.0", dev: true},
+		{id: "e@1.0.0"},
+		{id: "tarball@file:vendor/tarball-1.0.0.tgz"},
+	}
+	if got := summarizeDependencies(deps); !reflect.DeepEqual(got, want) {
+		t.Errorf("resolveLockfile() = %+v, want %+v", got, want)
+	}
+
+	for _, d := range deps {
+		if d.Name != "a" {
+			continue
+		}
+		if d.Resolved != "https://registry.npmjs.org/a/-/a-1.0.0.tgz" {
+			t.Errorf("Resolved = %q", d.Resolved)
+		}
+		wantChecksums := []*Checksum{{Algorithm: ChecksumSHA1, Value: strings.Repeat("0", 40)}}
+		if !reflect.DeepEqual(d.Checksums, wantChecksums) {
+			t.Errorf("Checksums = %v, want %v", d.Checksums, wantChecksums)
+		}
+		if want := filepath.Join(dir, "node_modules", "a"); d.Path != want {
+			t.Errorf("Path = %q, want %q", d.Path, want)
+		}
+	}
+}
+
+const npmTestPackages = `{
+	"": {
+		"name": "app",
+		"version": "1.0.0",
+		"workspaces": ["packages/*"],
+		"dependencies": {"a": "^1.0.0", "alias": "npm:@scope/real@^3.0.0"},
+		"devDependencies": {"d": "^1.0.0"},
+		"optionalDependencies": {"o": "^1.0.0"},
+		"peerDependencies": {"p": "^1.0.0", "missing": "^1.0.0"}
+	},
+	"node_modules/a": {
+		"version": "1.0.0",
+		"license": "MIT",
+		"dependencies": {"b": "^1.0.0"},
+		"bundleDependencies": ["b"]
+	},
+	"node_modules/a/node_modules/b": {"version": "1.0.0", "inBundle": true},
+	"node_modules/alias": {"name": "@scope/real", "version": "3.0.0"},
+	"node_modules/d": {"version": "1.0.0", "dev": true},
+	"node_modules/o": {"version": "1.0.0", "optional": true},
+	"node_modules/p": {"version": "1.0.0", "peer": true},
+	"node_modules/ws": {"resolved": "packages/ws", "link": true},
+	"packages/ws": {"name": "ws", "version": "0.1.0", "dependencies": {"a": "^1.0.0"}}
+}`
+
+var npmTestPackagesWant = []dependencySummary{
+	{id: "@scope/real@3.0.0"},
+	{id: "a@1.0.0", deps: []string{"b=b@1.0.0 BUNDLED"}},
+	{id: "app@1.0.0", project: true, deps: []string{
+		"a=a@1.0.0", "alias=@scope/real@3.0.0", "d=d@1.0.0 DEV", "o=o@1.0.0 OPTIONAL", "p=p@1.0.0 PEER",
+	}},
+	{id: "b@1.0.0"},
+	{id: "d@1.0.0", dev: true},
+	{id: "o@1.0.0"},
+	{id: "p@1.0.0"},
+	{id: "ws@0.1.0", project: true, deps: []string{"a=a@1.0.0"}},
+}
+
+func TestNpmLockfileV2(t *testing.T) {
+	dir := t.TempDir()
+	// The `dependencies` of version 1 are ignored when `packages` is present.
+	writeTestFiles(t, dir, map[string]string{
+		"package-lock.json": `{
+			"name": "app",
+			"version": "1.0.0",
+			"lockfileVersion": 2,
+			"packages": ` + npmTestPackages + `,
+			"dependencies": {"stale": {"version": "9.9.9"}}
+		}`,
+	})
+
+	if got := summarizeDependencies(resolveNpmTestLockfile(t, dir)); !reflect.DeepEqual(got, npmTestPackagesWant) {
+		t.Errorf("resolveLockfile() = %+v, want %+v", got, npmTestPackagesWant)
+	}
+}
+
+func TestNpmLockfileV3(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"package-lock.json": `{"name": "app", "version": "1.0.0", "lockfileVersion": 3, "packages": ` + npmTestPackages + `}`,
+	})
+
+	deps := resolveNpmTestLockfile(t, dir)
+	if got := summarizeDependencies(deps); !reflect.DeepEqual(got, npmTestPackagesWant) {
+		t.Errorf("resolveLockfile() = %+v, want %+v", got, npmTestPackagesWant)
+	}
+	for _, d := range deps {
+		if d.Name == "a" && d.License != "MIT" {
+			t.Errorf("License of a = %v, want MIT", d.License)
+		}
+	}
+}
+
+func TestNpmReadLockfile(t *testing.T) {
+	dir := t.TempDir()
+	// npm-shrinkwrap.json takes precedence over package-lock.json.
+	writeTestFiles(t, dir, map[string]string{
+		"npm-shrinkwrap.json":      `{"name": "shrinkwrap", "lockfileVersion": 3, "packages": {"": {"name": "shrinkwrap"}}}`,
+		"package-lock.json":        `{"name": "lock", "lockfileVersion": 3, "packages": {"": {"name": "lock"}}}`,
+		"broken/package-lock.json": `{"lockfileVersion": `,
+	})
+
+	n := &npm{}
+	if lockfile, err := n.readLockfile(dir); err != nil || lockfile.Name != "shrinkwrap" {
+		t.Errorf("readLockfile() = %+v, %v, want npm-shrinkwrap.json", lockfile, err)
+	}
+	_, err := n.readLockfile(filepath.Join(dir, "broken"))
+	if err == nil || !strings.Contains(err.Error(), "failed to parse package-lock.json") {
+		t.Errorf("readLockfile() error = %v, want a parse error", err)
+	}
+	if _, err := n.readLockfile(filepath.Join(dir, "missing")); err == nil {
+		t.Error("readLockfile() error = nil without a lockfile")
+	}
+}
+
+func TestNpmLockLinkTarget(t *testing.T) {
+	for _, tt := range []struct {
+		version string
+		want    string
+		wantOK  bool
+	}{
+		{"file:packages/foo", "packages/foo", true},
+		{"file:./packages/foo/", "packages/foo", true},
+		{"file:vendor/foo-1.0.0.tgz", "", false},
+		{"file:vendor/foo-1.0.0.tar.gz", "", false},
+		{"1.0.0", "", false},
+	} {
+		got, ok := (&npm{}).lockLinkTarget(tt.version)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("lockLinkTarget(%q) = %q, %v, want %q, %v", tt.version, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestNpmNameFromKey(t *testing.T) {
+	for _, tt := range []struct {
+		key, want string
+	}{
+		{"node_modules/foo", "foo"},
+		{"node_modules/foo/node_modules/@babel/core", "@babel/core"},
+		{"packages/ws", "ws"},
+	} {
+		if got := (&npm{}).nameFromKey(tt.key); got != tt.want {
+			t.Errorf("nameFromKey(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
@@ -1,30 +1,115 @@
 package pkgmanager
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"github.com/package-url/packageurl-go"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"time"
 )
 
 type PackageID string
 
+var invalidIDCharRe = regexp.MustCompile(`[^A-Za-z0-9.-]`)
+
 type QueryResult struct {
 	Packages     map[PackageID]*Package `json:"packages"`
 	Dependencies []*PackageDependency   `json:"dependencies"`
+	// Roots are the packages of the project that was scanned, such as the application of a lockfile, from which the
+	// other packages are reached through Dependencies. Package managers of the system have no roots.
+	Roots []PackageID `json:"roots,omitempty"`
 }
 
 type Package struct {
-	ID           PackageID              `json:"id"`
-	Name         string                 `json:"name"`
-	Namespace    string                 `json:"namespace"`
-	Version      string                 `json:"version"`
-	Licenses     []*License             `json:"licenses"`
-	LicenseFiles []*LicenseFile         `json:"licenseFiles"`
-	HomepageUrl  string                 `json:"homepageUrl"`
-	DownloadUrl  string                 `json:"downloadUrl"`
-	SourceInfo   string                 `json:"sourceInfo"`
-	Filename     string                 `json:"filename"`
-	PackageURL   *packageurl.PackageURL `json:"purl"`
+	ID            PackageID              `json:"id"`
+	Name          string                 `json:"name"`
+	Namespace     string                 `json:"namespace"`
+	Version       string                 `json:"version"`
+	Architecture  string                 `json:"architecture"`
+	Licenses      []*License             `json:"licenses"`
+	LicenseFiles  []*LicenseFile         `json:"licenseFiles"`
+	CopyrightText string                 `json:"copyrightText"`
+	Copyrights    []*Copyright           `json:"copyrights"`
+	HomepageUrl   string                 `json:"homepageUrl"`
+	DownloadUrl   string                 `json:"downloadUrl"`
+	SourceInfo    string                 `json:"sourceInfo"`
+	Filename      string                 `json:"filename"`
+	Checksums     []*Checksum            `json:"checksums"`
+	PackageURL    *packageurl.PackageURL `json:"purl"`
+	Source        *SourcePackage         `json:"source"`
+	Supplier      *Supplier              `json:"supplier"`
+	BuildHost     string                 `json:"buildHost"`
+	BuildTime     *time.Time             `json:"buildTime"`
+	Signature     *Signature             `json:"signature"`
+	Files         []*File                `json:"files"`
+	Verification  *Verification          `json:"verification"`
+}
+
+// SourcePackage is the package that a binary package was built from. Binary packages built from the same source share
+// the same ID.
+type SourcePackage struct {
+	ID         PackageID
+	Name       string
+	Version    string
+	PackageURL *packageurl.PackageURL
+}
+
+// Copyright is the copyright holders and the license of the files of a package that match glob patterns, e.g. `*` for
+// all files and `src/foo/*` for the files under a directory. The patterns may refer to the files of the source package.
+type Copyright struct {
+	Patterns []string `json:"patterns"`
+	Holders  []string `json:"holders"`
+	License  *License `json:"license"`
+}
+
+type SupplierType string
+
+const (
+	SupplierOrganization SupplierType = "Organization"
+	SupplierPerson       SupplierType = "Person"
+)
+
+// Supplier is the organization or person that distributed a package.
+type Supplier struct {
+	Name string       `json:"name"`
+	Type SupplierType `json:"type"`
+}
+
+// Signature is the OpenPGP signature of a package. Package managers that record signatures set a Signature without a
+// key ID for unsigned packages.
+type Signature struct {
+	KeyID     string `json:"keyId,omitempty"`
+	Algorithm string `json:"algorithm,omitempty"`
+}
+
+// Signed reports whether the package has a signature.
+func (s *Signature) Signed() bool {
+	return s.KeyID != ""
+}
+
+// File is a file installed by a package.
+type File struct {
+	Path      string      `json:"path"`
+	Checksums []*Checksum `json:"checksums"`
+}
+
+type ChecksumAlgorithm string
+
+const (
+	ChecksumMD5    ChecksumAlgorithm = "MD5"
+	ChecksumSHA1   ChecksumAlgorithm = "SHA1"
+	ChecksumSHA224 ChecksumAlgorithm = "SHA224"
+	ChecksumSHA256 ChecksumAlgorithm = "SHA256"
+	ChecksumSHA384 ChecksumAlgorithm = "SHA384"
+	ChecksumSHA512 ChecksumAlgorithm = "SHA512"
+)
+
+type Checksum struct {
+	Algorithm ChecksumAlgorithm `json:"algorithm"`
+	Value     string            `json:"value"`
 }
 
 type PackageDependency struct {
@@ -35,35 +120,92 @@ type PackageDependency struct {
 
 type DependencyType string
 
+const (
+	// DependencyTypeDependsOn is a dependency that must be installed for the requiring package to work.
+	DependencyTypeDependsOn DependencyType = "DEPENDS_ON"
+	// DependencyTypePreDepends is a dependency that must be fully configured before the requiring package is unpacked.
+	DependencyTypePreDepends DependencyType = "PRE_DEPENDS"
+	// DependencyTypeRecommends is a dependency that is installed along with the requiring package by default.
+	DependencyTypeRecommends DependencyType = "RECOMMENDS"
+	// DependencyTypeSuggests is a dependency that may enhance the requiring package but is not needed by it.
+	DependencyTypeSuggests DependencyType = "SUGGESTS"
+	// DependencyTypeWeak is a dependency that the required package declares on its own to extend the requiring package,
+	// such as Supplements and Enhances of rpm.
+	DependencyTypeWeak DependencyType = "WEAK"
+	// DependencyTypeDev is a dependency that is only needed to develop and test the requiring package.
+	DependencyTypeDev DependencyType = "DEV"
+	// DependencyTypeOptional is a dependency that the requiring package works without if it fails to install.
+	DependencyTypeOptional DependencyType = "OPTIONAL"
+	// DependencyTypePeer is a dependency that the requiring package expects the package that depends on it to provide.
+	DependencyTypePeer DependencyType = "PEER"
+	// DependencyTypeBundled is a dependency that is shipped inside the requiring package.
+	DependencyTypeBundled DependencyType = "BUNDLED"
+)
+
 type packageForEncoding struct {
-	Name         string         `json:"name"`
-	Namespace    string         `json:"namespace"`
-	Version      string         `json:"version"`
-	Licenses     []*License     `json:"licenses"`
-	LicenseFiles []*LicenseFile `json:"licenseFiles"`
-	HomepageUrl  string         `json:"homepageUrl"`
-	DownloadUrl  string         `json:"downloadUrl"`
-	Filename     string         `json:"filename"`
-	PackageURL   string         `json:"purl"`
+	Name          string             `json:"name"`
+	Namespace     string             `json:"namespace"`
+	Version       string             `json:"version"`
+	Architecture  string             `json:"architecture,omitempty"`
+	Licenses      []*License         `json:"licenses"`
+	LicenseFiles  []*LicenseFile     `json:"licenseFiles"`
+	CopyrightText string             `json:"copyrightText,omitempty"`
+	Copyrights    []*Copyright       `json:"copyrights,omitempty"`
+	HomepageUrl   string             `json:"homepageUrl"`
+	DownloadUrl   string             `json:"downloadUrl"`
+	Filename      string             `json:"filename"`
+	Checksums     []*Checksum        `json:"checksums,omitempty"`
+	PackageURL    string             `json:"purl"`
+	Source        *sourceForEncoding `json:"source,omitempty"`
+	Supplier      *Supplier          `json:"supplier,omitempty"`
+	BuildHost     string             `json:"buildHost,omitempty"`
+	BuildTime     *time.Time         `json:"buildTime,omitempty"`
+	Signature     *Signature         `json:"signature,omitempty"`
+	Files         []*File            `json:"files,omitempty"`
+	Verification  *Verification      `json:"verification,omitempty"`
+}
+
+type sourceForEncoding struct {
+	Name       string `json:"name"`
+	Version    string `json:"version"`
+	PackageURL string `json:"purl"`
 }
 
 func (p *Package) MarshalJSON() ([]byte, error) {
 	pfe := &packageForEncoding{
-		Name:         p.Name,
-		Namespace:    p.Namespace,
-		Version:      p.Version,
-		Licenses:     p.Licenses,
-		LicenseFiles: p.LicenseFiles,
-		HomepageUrl:  p.HomepageUrl,
-		DownloadUrl:  p.DownloadUrl,
-		Filename:     p.Filename,
-		PackageURL:   p.PackageURL.String(),
+		Name:          p.Name,
+		Namespace:     p.Namespace,
+		Version:       p.Version,
+		Architecture:  p.Architecture,
+		Licenses:      p.Licenses,
+		LicenseFiles:  p.LicenseFiles,
+		CopyrightText: p.CopyrightText,
+		Copyrights:    p.Copyrights,
+		HomepageUrl:   p.HomepageUrl,
+		DownloadUrl:   p.DownloadUrl,
+		Filename:      p.Filename,
+		Checksums:     p.Checksums,
+		PackageURL:    p.PackageURL.String(),
+		Supplier:      p.Supplier,
+		BuildHost:     p.BuildHost,
+		BuildTime:     p.BuildTime,
+		Signature:     p.Signature,
+		Files:         p.Files,
+		Verification:  p.Verification,
+	}
+	if p.Source != nil {
+		pfe.Source = &sourceForEncoding{
+			Name:       p.Source.Name,
+			Version:    p.Source.Version,
+			PackageURL: p.Source.PackageURL.String(),
+		}
 	}
 	return json.Marshal(pfe)
 }
 
 type License struct {
-	Name string `json:"name"`
+	Name           string `json:"name"`
+	SpdxExpression string `json:"spdxExpression"`
 }
 
 type LicenseFile struct {
@@ -71,16 +213,58 @@ type LicenseFile struct {
 	Content string `json:"content"`
 }
 
+// Options changes what package managers query in addition to the packages.
+type Options struct {
+	// Files makes package managers list the files installed by each package with their checksums.
+	Files bool
+	// Verify makes package managers compare the installed files with the checksums recorded at installation time.
+	Verify bool
+	// OmitDev makes package managers leave out the packages that are only needed to develop the project.
+	OmitDev bool
+	// Path is the directory that package managers of projects search recursively for projects. Only the current
+	// directory is looked at if empty.
+	Path string
+	// Root is the directory that the file system of the system to query is found at, such as an extracted container
+	// image, which is / if empty. Paths in the results stay as seen from the system itself.
+	Root string
+}
+
+var options Options
+
+// SetOptions sets the options used by subsequent queries.
+func SetOptions(o Options) {
+	options = o
+	projectLockfiles, projectLockfilesFound = nil, false
+}
+
+// rootPath returns where an absolute path of the system to query is found, which is under Options.Root if set.
+func rootPath(path string) string {
+	if options.Root == "" {
+		return path
+	}
+	return filepath.Join(options.Root, path)
+}
+
 type PackageManager interface {
 	Query() (*QueryResult, []error)
 	String() string
 	Available() bool
 }
 
+// ProjectPackageManager is a package manager of projects, which may be anywhere under Options.Path. Query only looks at
+// Options.Path itself.
+type ProjectPackageManager interface {
+	PackageManager
+	// QueryProjects returns the result of each project keyed by its directory.
+	QueryProjects() (map[string]*QueryResult, []error)
+}
+
 var toolMap map[string]PackageManager = map[string]PackageManager{
 	"dpkg": &dpkg{},
 	"rpm":  &rpm{},
 	"npm":  &npm{},
+	"yarn": &yarn{},
+	"pnpm": &pnpm{},
 }
 
 func GetAvailablePackageManagers() []PackageManager {
@@ -107,10 +291,17 @@ func GetPackageManagers(toolNames []string) []PackageManager {
 	return tools
 }
 
-func packageID(name, version string) PackageID {
-	id := name + "-" + version
-	id = strings.ReplaceAll(id, "@", "")
-	id = strings.ReplaceAll(id, "/", "-")
+// packageID joins parts such as the name and the version of a package into an ID. If the result has characters that
+// are not allowed in SPDX identifiers, such as the `@` and `/` of npm scopes, they are replaced with hyphens and a short
+// hash of the result is appended, so that IDs such as those of `1.0~rc1` and `1.0-rc1` or `@types/node` and `types-node`
+// stay distinct. Other IDs are left as they are.
+func packageID(parts ...string) PackageID {
+	id := strings.Join(parts, "-")
+	if !invalidIDCharRe.MatchString(id) {
+		return PackageID(id)
+	}
 
-	return PackageID(id)
+	sum := sha256.Sum256([]byte(id))
+	readable := invalidIDCharRe.ReplaceAllString(strings.ReplaceAll(id, "@", ""), "-")
+	return PackageID(readable + "-" + hex.EncodeToString(sum[:4]))
 }
@@ -0,0 +1,45 @@
+package pkgmanager
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPackageID(t *testing.T) {
+	for _, tt := range []struct {
+		parts []string
+		want  string
+	}{
+		{[]string{"lodash", "4.17.21"}, "lodash-4.17.21"},
+		{[]string{"bash", "5.1.8-9.el9", "aarch64"}, "bash-5.1.8-9.el9-aarch64"},
+		{[]string{"types-node", "20.0.0"}, "types-node-20.0.0"},
+		{[]string{"@types/node", "20.0.0"}, "types-node-20.0.0-"},
+		{[]string{"a", "1.0~rc1"}, "a-1.0-rc1-"},
+		{[]string{"libc6", "2.36-9+deb12u4", "amd64"}, "libc6-2.36-9-deb12u4-amd64-"},
+	} {
+		got := string(packageID(tt.parts...))
+		if strings.HasSuffix(tt.want, "-") {
+			// The hash follows the readable part.
+			if !strings.HasPrefix(got, tt.want) || len(got) != len(tt.want)+8 {
+				t.Errorf("packageID(%q) = %q, want %q followed by a hash", tt.parts, got, tt.want)
+			}
+		} else if got != tt.want {
+			t.Errorf("packageID(%q) = %q, want %q", tt.parts, got, tt.want)
+		}
+		if invalidIDCharRe.MatchString(got) {
+			t.Errorf("packageID(%q) = %q, which is not a valid SPDX identifier", tt.parts, got)
+		}
+	}
+
+	// IDs that differ only in the characters that are replaced must stay distinct.
+	for _, pair := range [][2][]string{
+		{{"@types/node", "20.0.0"}, {"types-node", "20.0.0"}},
+		{{"a", "1.0~rc1"}, {"a", "1.0-rc1"}},
+		{{"a", "1.0+b1"}, {"a", "1.0-b1"}},
+		{{"a", "1.0+b1"}, {"a", "1.0~b1"}},
+	} {
+		if a, b := packageID(pair[0]...), packageID(pair[1]...); a == b {
+			t.Errorf("packageID(%q) and packageID(%q) are both %q", pair[0], pair[1], a)
+		}
+	}
+}
@@ -0,0 +1,363 @@
+package pkgmanager
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/base32"
+	"fmt"
+	"github.com/Hitachi/spirat/utils"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	pnpmLockfileName = "pnpm-lock.yaml"
+	// pnpmDefaultRegistry is the registry that pnpm downloads packages from unless .npmrc says otherwise.
+	pnpmDefaultRegistry = "https://registry.npmjs.org"
+	// pnpmVirtualStoreMaxLength is the default length of the directories in the virtual store above which pnpm
+	// shortens them.
+	pnpmVirtualStoreMaxLength = 120
+)
+
+// pnpm reads pnpm-lock.yaml. It embeds npm as the packages come from the same registry.
+type pnpm struct {
+	npm
+}
+
+// pnpmLockfile is pnpm-lock.yaml. A lockfile without workspaces has the dependencies of the project at the top instead of
+// in importers. Version 9 moves the dependencies of packages from packages into snapshots.
+type pnpmLockfile struct {
+	LockfileVersion interface{}              `yaml:"lockfileVersion"`
+	Importers       map[string]*pnpmImporter `yaml:"importers"`
+	Packages        map[string]*pnpmPackage  `yaml:"packages"`
+	Snapshots       map[string]*pnpmPackage  `yaml:"snapshots"`
+	pnpmImporter    `yaml:",inline"`
+}
+
+// pnpmImporter is a project in the workspace keyed by its directory, where `.` is the root.
+type pnpmImporter struct {
+	Dependencies         map[string]pnpmImporterDependency `yaml:"dependencies"`
+	DevDependencies      map[string]pnpmImporterDependency `yaml:"devDependencies"`
+	OptionalDependencies map[string]pnpmImporterDependency `yaml:"optionalDependencies"`
+}
+
+// pnpmImporterDependency is the version that a dependency of a project resolved to. Version 5 writes the version alone,
+// and later versions write it along with the specifier in package.json.
+type pnpmImporterDependency struct {
+	Version string
+}
+
+func (d *pnpmImporterDependency) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		d.Version = node.Value
+		return nil
+	}
+
+	var v struct {
+		Version string `yaml:"version"`
+	}
+	if err := node.Decode(&v); err != nil {
+		return err
+	}
+	d.Version = v.Version
+	return nil
+}
+
+// pnpmPackage is an entry of packages or snapshots keyed by its dependency path, e.g. `/react-dom/18.2.0_react@18.2.0` in
+// version 5, `/react-dom@18.2.0(react@18.2.0)` in version 6 and `react-dom@18.2.0(react@18.2.0)` in version 9. The
+// suffix distinguishes the copies of a package that have different peer dependencies.
+type pnpmPackage struct {
+	Resolution struct {
+		Tarball   string `yaml:"tarball"`
+		Integrity string `yaml:"integrity"`
+	} `yaml:"resolution"`
+	// Name and Version are only present for packages that are not from the registry, such as tarballs and Git.
+	Name                 string            `yaml:"name"`
+	Version              string            `yaml:"version"`
+	Dependencies         map[string]string `yaml:"dependencies"`
+	OptionalDependencies map[string]string `yaml:"optionalDependencies"`
+	// PeerDependencies are the ranges of the peer dependencies, which are resolved in Dependencies.
+	PeerDependencies map[string]string `yaml:"peerDependencies"`
+}
+
+func (p *pnpm) Query() (*QueryResult, []error) {
+	return p.queryProject(projectPath())
+}
+
+func (p *pnpm) QueryProjects() (map[string]*QueryResult, []error) {
+	return queryProjects([]string{pnpmLockfileName}, p.queryProject)
+}
+
+func (p *pnpm) String() string {
+	return "pnpm"
+}
+
+func (p *pnpm) Available() bool {
+	return len(findProjects([]string{pnpmLockfileName})) > 0
+}
+
+// queryProject returns the packages of the project in dir.
+func (p *pnpm) queryProject(dir string) (*QueryResult, []error) {
+	data, err := os.ReadFile(filepath.Join(dir, pnpmLockfileName))
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	var lockfile pnpmLockfile
+	if err := yaml.Unmarshal(data, &lockfile); err != nil {
+		return nil, []error{fmt.Errorf("failed to parse %s: %w", pnpmLockfileName, err)}
+	}
+
+	deps, err := p.resolveLockfile(dir, &lockfile)
+	if err != nil {
+		return nil, []error{fmt.Errorf("failed to parse %s: %w", pnpmLockfileName, err)}
+	}
+
+	return p.newQueryResult(deps)
+}
+
+// resolveLockfile returns the packages in pnpm-lock.yaml of dir and the projects in the workspace with their
+// dependencies resolved.
+func (p *pnpm) resolveLockfile(dir string, lockfile *pnpmLockfile) ([]*dependency, error) {
+	major, _, _ := strings.Cut(fmt.Sprint(lockfile.LockfileVersion), ".")
+	switch major {
+	case "5", "6", "9":
+	default:
+		return nil, fmt.Errorf("unsupported lockfile version %v", lockfile.LockfileVersion)
+	}
+
+	if lockfile.Importers == nil {
+		lockfile.Importers = map[string]*pnpmImporter{".": &lockfile.pnpmImporter}
+	}
+	// Version 9 keeps the dependencies in snapshots and the rest in packages, which is keyed without the suffix.
+	snapshots := lockfile.Snapshots
+	if major != "9" {
+		snapshots = lockfile.Packages
+	}
+
+	registry := p.readRegistry(dir)
+	deps := make(map[string]*dependency)
+	for _, key := range utils.SortedKeys(snapshots) {
+		name, version := p.splitDependencyPath(major, key)
+		info := p.packageInfo(lockfile, major, key)
+		if info.Name != "" {
+			name, version = info.Name, info.Version
+		}
+
+		d := &dependency{
+			Name:         name,
+			Version:      version,
+			Resolved:     info.Resolution.Tarball,
+			Checksums:    p.integrityChecksums(info.Resolution.Integrity),
+			Dependencies: make(map[string]*dependency),
+		}
+		if d.Resolved == "" && info.Name == "" {
+			_, base := p.splitToNamespaceAndName(name)
+			d.Resolved = fmt.Sprintf("%s/%s/-/%s-%s.tgz", registry, name, base, version)
+		}
+		// Every copy is in the virtual store, which hoisted installs do not have, so the copy at the top is tried next.
+		for _, pkgDir := range []string{
+			filepath.Join(dir, "node_modules", ".pnpm", p.virtualStoreName(major, key), "node_modules", name),
+			filepath.Join(dir, "node_modules", name),
+		} {
+			if pj, err := p.parsePackageJson(filepath.Join(pkgDir, "package.json")); err == nil && pj.Version == version {
+				d.Path = pkgDir
+				break
+			}
+		}
+		deps[key] = d
+	}
+
+	// The projects in the workspace are packages as well.
+	for _, importer := range utils.SortedKeys(lockfile.Importers) {
+		deps[importer] = p.projectDependency(filepath.Join(dir, filepath.FromSlash(importer)))
+	}
+
+	var ret []*dependency
+	for _, key := range utils.SortedKeys(snapshots) {
+		d := deps[key]
+		s := snapshots[key]
+		info := p.packageInfo(lockfile, major, key)
+		for _, required := range []struct {
+			names map[string]string
+			typ   DependencyType
+		}{
+			{s.Dependencies, DependencyTypeDependsOn},
+			{s.OptionalDependencies, DependencyTypeOptional},
+		} {
+			for name, ref := range required.names {
+				found, ok := deps[p.dependencyPath(major, name, ref)]
+				if !ok {
+					continue
+				}
+				typ := required.typ
+				if _, ok := info.PeerDependencies[name]; ok {
+					typ = DependencyTypePeer
+				}
+				d.addDependency(name, found, typ)
+			}
+		}
+		ret = append(ret, d)
+	}
+
+	// The projects in the workspace are needed in production along with their dependencies other than dev ones.
+	var prod []*dependency
+	for _, project := range utils.SortedKeys(lockfile.Importers) {
+		importer := lockfile.Importers[project]
+		d := deps[project]
+		prod = append(prod, d)
+		for _, required := range []struct {
+			names map[string]pnpmImporterDependency
+			typ   DependencyType
+		}{
+			{importer.DevDependencies, DependencyTypeDev},
+			{importer.Dependencies, DependencyTypeDependsOn},
+			{importer.OptionalDependencies, DependencyTypeOptional},
+		} {
+			for name, ref := range required.names {
+				if found := p.resolveImporterDependency(deps, major, project, name, ref.Version); found != nil {
+					d.addDependency(name, found, required.typ)
+				}
+			}
+		}
+		ret = append(ret, d)
+	}
+	p.markDevDependencies(ret, prod)
+
+	return ret, nil
+}
+
+// packageInfo returns the entry of packages for the key of snapshots, which is the snapshot itself before version 9.
+func (p *pnpm) packageInfo(lockfile *pnpmLockfile, major, key string) *pnpmPackage {
+	if major != "9" {
+		return lockfile.Packages[key]
+	}
+	if info, ok := lockfile.Packages[p.trimPeerSuffix(major, key)]; ok {
+		return info
+	}
+	return lockfile.Snapshots[key]
+}
+
+// resolveImporterDependency returns the package that a dependency of the project in importers resolved to, or nil if
+// there is none.
+func (p *pnpm) resolveImporterDependency(deps map[string]*dependency, major, project, name, ref string) *dependency {
+	// A link refers to another project by its path relative to the project.
+	if target, ok := strings.CutPrefix(ref, "link:"); ok {
+		return deps[path.Join(project, target)]
+	}
+	return deps[p.dependencyPath(major, name, ref)]
+}
+
+// dependencyPath returns the key in packages or snapshots that a dependency resolved to. The reference is the version,
+// possibly with the suffix of peer dependencies, or the dependency path of another package for aliases.
+func (p *pnpm) dependencyPath(major, name, ref string) string {
+	if strings.HasPrefix(ref, "/") {
+		return ref
+	}
+	if major == "9" {
+		if strings.Contains(strings.TrimPrefix(p.trimPeerSuffix(major, ref), "@"), "@") {
+			return ref
+		}
+		return name + "@" + ref
+	}
+	if major == "6" {
+		return "/" + name + "@" + ref
+	}
+	return "/" + name + "/" + ref
+}
+
+// splitDependencyPath returns the name and the version in a dependency path of the registry.
+func (p *pnpm) splitDependencyPath(major, key string) (string, string) {
+	key = strings.TrimPrefix(p.trimPeerSuffix(major, key), "/")
+	if major == "5" {
+		i := strings.LastIndex(key, "/")
+		if i < 0 {
+			return key, ""
+		}
+		return key[:i], key[i+1:]
+	}
+
+	i := strings.LastIndex(key, "@")
+	if i <= 0 {
+		return key, ""
+	}
+	return key[:i], key[i+1:]
+}
+
+// trimPeerSuffix removes the peer dependencies from a dependency path, which follow an underscore in version 5 and are
+// in parentheses in later versions.
+func (p *pnpm) trimPeerSuffix(major, key string) string {
+	if major == "5" {
+		// The name may contain underscores, but the version may not.
+		if i := strings.LastIndex(key, "/"); i >= 0 {
+			if j := strings.Index(key[i:], "_"); j >= 0 {
+				return key[:i+j]
+			}
+		}
+		return key
+	}
+
+	if i := strings.Index(key, "("); i >= 0 {
+		return key[:i]
+	}
+	return key
+}
+
+// virtualStoreName returns the directory in node_modules/.pnpm that pnpm installs the package of a dependency path
+// into, e.g. `@types+react@18.2.0` for `@types/react@18.2.0` and `react-dom@18.2.0_react@18.2.0` for
+// `react-dom@18.2.0(react@18.2.0)`. Names that are too long or not in lower case are shortened with a hash as pnpm does.
+func (p *pnpm) virtualStoreName(major, key string) string {
+	name := key
+	if rest, ok := strings.CutPrefix(name, "file:"); ok {
+		name = "file+" + rest
+	} else {
+		name = strings.TrimPrefix(name, "/")
+		if major == "5" {
+			if i := strings.LastIndex(name, "/"); i >= 0 {
+				name = name[:i] + "@" + name[i+1:]
+			}
+		}
+	}
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`\/:*?"<>|`, r) {
+			return '+'
+		}
+		return r
+	}, name)
+	if strings.Contains(name, "(") {
+		// Peer dependencies may have peer dependencies of their own, whose parentheses are nested.
+		name = strings.NewReplacer(")(", "_", "(", "_", ")", "_").Replace(strings.TrimSuffix(name, ")"))
+	}
+
+	if len(name) > pnpmVirtualStoreMaxLength || name != strings.ToLower(name) && !strings.HasPrefix(name, "file+") {
+		sum := md5.Sum([]byte(name))
+		hash := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(sum[:]))
+		if len(name) > pnpmVirtualStoreMaxLength-27 {
+			name = name[:pnpmVirtualStoreMaxLength-27]
+		}
+		return name + "_" + hash
+	}
+	return name
+}
+
+// readRegistry returns the registry configured in .npmrc of dir, which the URLs of packages are derived from as
+// pnpm-lock.yaml does not record them.
+func (p *pnpm) readRegistry(dir string) string {
+	file, err := os.Open(filepath.Join(dir, ".npmrc"))
+	if err != nil {
+		return pnpmDefaultRegistry
+	}
+	defer file.Close()
+
+	s := bufio.NewScanner(file)
+	for s.Scan() {
+		if key, value, ok := strings.Cut(s.Text(), "="); ok && strings.TrimSpace(key) == "registry" {
+			return strings.TrimSuffix(strings.TrimSpace(value), "/")
+		}
+	}
+
+	return pnpmDefaultRegistry
+}
@@ -0,0 +1,357 @@
+package pkgmanager
+
+import (
+	"gopkg.in/yaml.v3"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func resolvePnpmTestLockfile(t *testing.T, dir, content string) []*dependency {
+	t.Helper()
+	var lockfile pnpmLockfile
+	if err := yaml.Unmarshal([]byte(content), &lockfile); err != nil {
+		t.Fatalf("failed to parse the lockfile: %v", err)
+	}
+	deps, err := (&pnpm{}).resolveLockfile(dir, &lockfile)
+	if err != nil {
+		t.Fatalf("resolveLockfile() error = %v", err)
+	}
+	return deps
+}
+
+// pnpmTestWant is what the lockfiles of each version resolve to without workspaces.
+var pnpmTestWant = []dependencySummary{
+	{id: "app@1.0.0", project: true, deps: []string{"d=d@1.0.0 DEV", "react-dom=react-dom@18.2.0", "react=react@18.2.0"}},
+	{id: "d@1.0.0", dev: true},
+	{id: "loose-envify@1.4.0"},
+	{id: "react-dom@18.2.0", deps: []string{"loose-envify=loose-envify@1.4.0", "react=react@18.2.0 PEER"}},
+	{id: "react@18.2.0", deps: []string{"loose-envify=loose-envify@1.4.0"}},
+}
+
+func TestPnpmLockfileV5(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"package.json": `{"name": "app", "version": "1.0.0"}`})
+
+	deps := resolvePnpmTestLockfile(t, dir, `lockfileVersion: 5.4
+
+specifiers:
+  d: ^1.0.0
+  react: ^18.2.0
+  react-dom: ^18.2.0
+
+dependencies:
+  react: 18.2.0
+  react-dom: 18.2.0_react@18.2.0
+
+devDependencies:
+  d: 1.0.0
+
+packages:
+
+  /d/1.0.0:
+    resolution: {integrity: sha1-AAAAAAAAAAAAAAAAAAAAAAAAAAA=}
+    dev: true
+
+  /loose-envify/1.4.0:
+    resolution: {integrity: sha1-AAAAAAAAAAAAAAAAAAAAAAAAAAA=}
+    dev: false
+
+  /react-dom/18.2.0_react@18.2.0:
+    resolution: {integrity: sha1-AAAAAAAAAAAAAAAAAAAAAAAAAAA=}
+    peerDependencies:
+      react: ^18.2.0
+    dependencies:
+      loose-envify: 1.4.0
+      react: 18.2.0
+    dev: false
+
+  /react/18.2.0:
+    resolution: {integrity: sha1-AAAAAAAAAAAAAAAAAAAAAAAAAAA=}
+    dependencies:
+      loose-envify: 1.4.0
+    dev: false
+`)
+
+	if got := summarizeDependencies(deps); !reflect.DeepEqual(got, pnpmTestWant) {
+		t.Errorf("resolveLockfile() = %+v, want %+v", got, pnpmTestWant)
+	}
+}
+
+func TestPnpmLockfileV6(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"package.json": `{"name": "app", "version": "1.0.0"}`,
+		".npmrc":       "registry = https://npm.example.com/\n",
+	})
+
+	deps := resolvePnpmTestLockfile(t, dir, `lockfileVersion: '6.0'
+
+dependencies:
+  react:
+    specifier: ^18.2.0
+    version: 18.2.0
+  react-dom:
+    specifier: ^18.2.0
+    version: 18.2.0(react@18.2.0)
+
+devDependencies:
+  d:
+    specifier: ^1.0.0
+    version: 1.0.0
+
+packages:
+
+  /d@1.0.0:
+    resolution: {integrity: sha1-AAAAAAAAAAAAAAAAAAAAAAAAAAA=}
+    dev: true
+
+  /loose-envify@1.4.0:
+    resolution: {integrity: sha1-AAAAAAAAAAAAAAAAAAAAAAAAAAA=}
+    dev: false
+
+  /react-dom@18.2.0(react@18.2.0):
+    resolution: {integrity: sha1-AAAAAAAAAAAAAAAAAAAAAAAAAAA=}
+    peerDependencies:
+      react: ^18.2.0
+    dependencies:
+      loose-envify: 1.4.0
+      react: 18.2.0
+    dev: false
+
+  /react@18.2.0:
+    resolution: {integrity: sha1-AAAAAAAAAAAAAAAAAAAAAAAAAAA=}
+    dependencies:
+      loose-envify: 1.4.0
+    dev: false
+`)
+
+	if got := summarizeDependencies(deps); !reflect.DeepEqual(got, pnpmTestWant) {
+		t.Errorf("resolveLockfile() = %+v, want %+v", got, pnpmTestWant)
+	}
+
+	// The URLs of tarballs are derived from the registry in .npmrc.
+	for _, d := range deps {
+		if d.Name == "react-dom" && d.Resolved != "https://npm.example.com/react-dom/-/react-dom-18.2.0.tgz" {
+			t.Errorf("Resolved of react-dom = %q", d.Resolved)
+		}
+	}
+}
+
+const pnpmTestLockfileV9 = `lockfileVersion: '9.0'
+
+importers:
+
+  .:
+    dependencies:
+      react:
+        specifier: ^18.2.0
+        version: 18.2.0
+      react-dom:
+        specifier: ^18.2.0
+        version: 18.2.0(react@18.2.0)
+      ws:
+        specifier: workspace:*
+        version: link:packages/ws
+    devDependencies:
+      d:
+        specifier: ^1.0.0
+        version: 1.0.0
+
+  packages/ws:
+    dependencies:
+      alias:
+        specifier: npm:react@^18.2.0
+        version: react@18.2.0
+      local:
+        specifier: file:../../vendor/local
+        version: file:vendor/local
+
+packages:
+
+  d@1.0.0:
+    resolution: {integrity: sha1-AAAAAAAAAAAAAAAAAAAAAAAAAAA=}
+
+  local@file:vendor/local:
+    resolution: {directory: vendor/local, type: directory}
+    name: local
+    version: 0.2.0
+
+  loose-envify@1.4.0:
+    resolution: {integrity: sha1-AAAAAAAAAAAAAAAAAAAAAAAAAAA=}
+
+  react-dom@18.2.0:
+    resolution: {integrity: sha1-AAAAAAAAAAAAAAAAAAAAAAAAAAA=}
+    peerDependencies:
+      react: ^18.2.0
+
+  react@18.2.0:
+    resolution: {integrity: sha1-AAAAAAAAAAAAAAAAAAAAAAAAAAA=}
+
+snapshots:
+
+  d@1.0.0: {}
+
+  local@file:vendor/local: {}
+
+  loose-envify@1.4.0: {}
+
+  react-dom@18.2.0(react@18.2.0):
+    dependencies:
+      loose-envify: 1.4.0
+      react: 18.2.0
+
+  react@18.2.0:
+    dependencies:
+      loose-envify: 1.4.0
+`
+
+func TestPnpmLockfileV9(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"package.json":             `{"name": "app", "version": "1.0.0"}`,
+		"packages/ws/package.json": `{"name": "ws", "version": "0.1.0"}`,
+	})
+
+	deps := resolvePnpmTestLockfile(t, dir, pnpmTestLockfileV9)
+	want := []dependencySummary{
+		{id: "app@1.0.0", project: true, deps: []string{
+			"d=d@1.0.0 DEV", "react-dom=react-dom@18.2.0", "react=react@18.2.0", "ws=ws@0.1.0",
+		}},
+		{id: "d@1.0.0", dev: true},
+		{id: "local@0.2.0"},
+		{id: "loose-envify@1.4.0"},
+		{id: "react-dom@18.2.0", deps: []string{"loose-envify=loose-envify@1.4.0", "react=react@18.2.0 PEER"}},
+		{id: "react@18.2.0", deps: []string{"loose-envify=loose-envify@1.4.0"}},
+		{id: "ws@0.1.0", project: true, deps: []string{"alias=react@18.2.0", "local=local@0.2.0"}},
+	}
+	if got := summarizeDependencies(deps); !reflect.DeepEqual(got, want) {
+		t.Errorf("resolveLockfile() = %+v, want %+v", got, want)
+	}
+
+	// Packages that are not from the registry have no URL to derive.
+	for _, d := range deps {
+		if d.Name == "local" && d.Resolved != "" {
+			t.Errorf("Resolved of local = %q, want none", d.Resolved)
+		}
+	}
+}
+
+func TestPnpmUnsupportedLockfileVersion(t *testing.T) {
+	lockfile := &pnpmLockfile{LockfileVersion: "7.0"}
+	if _, err := (&pnpm{}).resolveLockfile(t.TempDir(), lockfile); err == nil {
+		t.Error("resolveLockfile() error = nil for version 7.0")
+	}
+}
+
+func TestPnpmDependencyPath(t *testing.T) {
+	for _, tt := range []struct {
+		major, name, ref string
+		want             string
+	}{
+		{"5", "react", "18.2.0", "/react/18.2.0"},
+		{"5", "react-dom", "18.2.0_react@18.2.0", "/react-dom/18.2.0_react@18.2.0"},
+		{"5", "alias", "/react/18.2.0", "/react/18.2.0"},
+		{"6", "@types/react", "18.2.0", "/@types/react@18.2.0"},
+		{"6", "react-dom", "18.2.0(react@18.2.0)", "/react-dom@18.2.0(react@18.2.0)"},
+		{"6", "alias", "/react@18.2.0", "/react@18.2.0"},
+		{"9", "@types/react", "18.2.0", "@types/react@18.2.0"},
+		{"9", "react-dom", "18.2.0(react@18.2.0)", "react-dom@18.2.0(react@18.2.0)"},
+		{"9", "alias", "react@18.2.0", "react@18.2.0"},
+		{"9", "alias", "@types/react@18.2.0", "@types/react@18.2.0"},
+		{"9", "foo", "1.0.0(@types/react@18.2.0)", "foo@1.0.0(@types/react@18.2.0)"},
+	} {
+		if got := (&pnpm{}).dependencyPath(tt.major, tt.name, tt.ref); got != tt.want {
+			t.Errorf("dependencyPath(%q, %q, %q) = %q, want %q", tt.major, tt.name, tt.ref, got, tt.want)
+		}
+	}
+}
+
+func TestPnpmSplitDependencyPath(t *testing.T) {
+	for _, tt := range []struct {
+		major, key    string
+		name, version string
+	}{
+		{"5", "/react/18.2.0", "react", "18.2.0"},
+		{"5", "/@types/react/18.2.0", "@types/react", "18.2.0"},
+		{"5", "/react-dom/18.2.0_react@18.2.0", "react-dom", "18.2.0"},
+		{"5", "/snake_case/1.0.0_react@18.2.0", "snake_case", "1.0.0"},
+		{"6", "/@types/react@18.2.0", "@types/react", "18.2.0"},
+		{"6", "/react-dom@18.2.0(react@18.2.0)", "react-dom", "18.2.0"},
+		{"9", "@types/react@18.2.0", "@types/react", "18.2.0"},
+		{"9", "react-dom@18.2.0(@types/react@18.2.0)(react@18.2.0)", "react-dom", "18.2.0"},
+		{"9", "react", "react", ""},
+	} {
+		if name, version := (&pnpm{}).splitDependencyPath(tt.major, tt.key); name != tt.name || version != tt.version {
+			t.Errorf("splitDependencyPath(%q, %q) = %q, %q, want %q, %q",
+				tt.major, tt.key, name, version, tt.name, tt.version)
+		}
+	}
+}
+
+func TestPnpmTrimPeerSuffix(t *testing.T) {
+	for _, tt := range []struct {
+		major, key, want string
+	}{
+		{"5", "/react-dom/18.2.0_react@18.2.0", "/react-dom/18.2.0"},
+		{"5", "/snake_case/1.0.0", "/snake_case/1.0.0"},
+		{"5", "/foo/1.0.0_@types+react@18.2.0", "/foo/1.0.0"},
+		{"6", "/react-dom@18.2.0(react@18.2.0)", "/react-dom@18.2.0"},
+		{"9", "foo@1.0.0(bar@2.0.0(baz@3.0.0))(react@18.2.0)", "foo@1.0.0"},
+		{"9", "snake_case@1.0.0", "snake_case@1.0.0"},
+	} {
+		if got := (&pnpm{}).trimPeerSuffix(tt.major, tt.key); got != tt.want {
+			t.Errorf("trimPeerSuffix(%q, %q) = %q, want %q", tt.major, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestPnpmVirtualStoreName(t *testing.T) {
+	longKey := "/@very-long-scope-name/very-long-package-name@1.0.0(@types/peer-package-number-0@1.0.0)" +
+		"(@types/peer-package-number-1@1.0.0)(@types/peer-package-number-2@1.0.0)"
+	for _, tt := range []struct {
+		major, key, want string
+	}{
+		{"5", "/react/18.2.0", "react@18.2.0"},
+		{"5", "/@types/react/18.2.0", "@types+react@18.2.0"},
+		{"5", "/react-dom/18.2.0_react@18.2.0", "react-dom@18.2.0_react@18.2.0"},
+		{"6", "/@types/react@18.2.0", "@types+react@18.2.0"},
+		{"6", "/react-dom@18.2.0(react@18.2.0)", "react-dom@18.2.0_react@18.2.0"},
+		{"9", "react-dom@18.2.0(@types/react@18.2.0)(react@18.2.0)", "react-dom@18.2.0_@types+react@18.2.0_react@18.2.0"},
+		{"9", "a@1.0.0(b@2.0.0(c@3.0.0))(d@4.0.0)", "a@1.0.0_b@2.0.0_c@3.0.0__d@4.0.0"},
+		{"9", "local@file:vendor/local", "local@file+vendor+local"},
+		{"9", "file:vendor/Local", "file+vendor+Local"},
+		// Names that are not in lower case or too long are shortened with a hash.
+		{"6", "/JSONStream@1.3.5", "JSONStream@1.3.5_r3os6ifnv5rfzwtsmjig3b325i"},
+		{"6", longKey, "@very-long-scope-name+very-long-package-name@1.0.0_@types+peer-package-number-0@1.0.0_@types+" +
+			"_5325pbvhe66qk2bdr4yxag3jiq"},
+	} {
+		if got := (&pnpm{}).virtualStoreName(tt.major, tt.key); got != tt.want {
+			t.Errorf("virtualStoreName(%q, %q) = %q, want %q", tt.major, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestPnpmVirtualStorePath(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"package.json":             `{"name": "app", "version": "1.0.0"}`,
+		"packages/ws/package.json": `{"name": "ws", "version": "0.1.0"}`,
+		"node_modules/.pnpm/react-dom@18.2.0_react@18.2.0/node_modules/react-dom/package.json": `{"version": "18.2.0"}`,
+		// A hoisted install has no virtual store, and a copy of another version is not the package.
+		"node_modules/react/package.json":        `{"version": "18.2.0"}`,
+		"node_modules/loose-envify/package.json": `{"version": "1.0.0"}`,
+	})
+
+	store := filepath.Join(dir, "node_modules", ".pnpm")
+	want := map[string]string{
+		"react-dom":    filepath.Join(store, "react-dom@18.2.0_react@18.2.0", "node_modules", "react-dom"),
+		"react":        filepath.Join(dir, "node_modules", "react"),
+		"loose-envify": "",
+	}
+	for _, d := range resolvePnpmTestLockfile(t, dir, pnpmTestLockfileV9) {
+		if w, ok := want[d.Name]; ok && d.Path != w {
+			t.Errorf("Path of %s = %q, want %q", d.Name, d.Path, w)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package pkgmanager
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const procMountsPath = "/proc/self/mounts"
+
+// pseudoFilesystemTypes are the file systems that hold no projects, such as /proc and /sys, which are skipped when
+// searching for projects.
+var pseudoFilesystemTypes = map[string]struct{}{
+	"autofs": {}, "binfmt_misc": {}, "bpf": {}, "cgroup": {}, "cgroup2": {}, "configfs": {}, "debugfs": {},
+	"devpts": {}, "devtmpfs": {}, "efivarfs": {}, "fusectl": {}, "hugetlbfs": {}, "mqueue": {}, "nsfs": {},
+	"proc": {}, "pstore": {}, "rpc_pipefs": {}, "securityfs": {}, "selinuxfs": {}, "sysfs": {}, "tracefs": {},
+}
+
+// mountPathReplacer undoes the escaping of the paths in /proc/self/mounts.
+var mountPathReplacer = strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`)
+
+// projectLockfiles are the lockfiles of npm, yarn and pnpm found in the search for projects, which is shared by the
+// package managers so that Options.Path is only searched once. SetOptions clears them.
+var projectLockfiles []string
+var projectLockfilesFound bool
+
+// projectPath returns the directory to look for projects in.
+func projectPath() string {
+	if options.Path == "" {
+		return "."
+	}
+	return options.Path
+}
+
+// findProjects returns the directories that contain any of lockfileNames. Only the current directory is looked at
+// unless Options.Path is set.
+func findProjects(lockfileNames []string) []string {
+	if !projectLockfilesFound {
+		projectLockfiles = findProjectLockfiles()
+		projectLockfilesFound = true
+	}
+
+	var dirs []string
+	for _, path := range projectLockfiles {
+		for _, name := range lockfileNames {
+			if filepath.Base(path) != name {
+				continue
+			}
+			// The files of a directory are found in order, so a directory with several lockfiles is next to itself.
+			if dir := filepath.Dir(path); len(dirs) == 0 || dirs[len(dirs)-1] != dir {
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+
+	return dirs
+}
+
+// findProjectLockfiles returns the lockfiles of npm, yarn and pnpm under Options.Path, or in the current directory if it
+// is not set. node_modules is skipped, as the packages in it belong to the project that installed them, and so are
+// hidden directories and pseudo file systems.
+func findProjectLockfiles() []string {
+	names := make(map[string]struct{})
+	for _, name := range append(append([]string{}, npmLockfileNames...), yarnLockfileName, pnpmLockfileName) {
+		names[name] = struct{}{}
+	}
+
+	var paths []string
+	if options.Path == "" {
+		entries, _ := os.ReadDir(".")
+		for _, e := range entries {
+			if _, ok := names[e.Name()]; ok && !e.IsDir() {
+				paths = append(paths, e.Name())
+			}
+		}
+		return paths
+	}
+
+	root := options.Path
+	pseudo := pseudoFilesystemMounts()
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Directories that cannot be read are skipped.
+			return nil
+		}
+		if d.IsDir() {
+			if path == root {
+				return nil
+			}
+			if d.Name() == "node_modules" || strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			if abs, err := filepath.Abs(path); err == nil {
+				if _, ok := pseudo[abs]; ok {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+
+		if _, ok := names[d.Name()]; ok {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+
+	return paths
+}
+
+// pseudoFilesystemMounts returns the directories that pseudo file systems are mounted at, which is empty on systems
+// without /proc.
+func pseudoFilesystemMounts() map[string]struct{} {
+	mounts := make(map[string]struct{})
+	file, err := os.Open(procMountsPath)
+	if err != nil {
+		return mounts
+	}
+	defer file.Close()
+
+	s := bufio.NewScanner(file)
+	for s.Scan() {
+		// Each line is the device, the mount point, the type and the options of a mount.
+		fields := strings.Fields(s.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		if _, ok := pseudoFilesystemTypes[fields[2]]; ok {
+			mounts[mountPathReplacer.Replace(fields[1])] = struct{}{}
+		}
+	}
+
+	return mounts
+}
+
+// queryProjects queries each project found by findProjects with query. A project that fails is left out, and the
+// errors are reported along with its directory.
+func queryProjects(lockfileNames []string, query func(dir string) (*QueryResult, []error)) (map[string]*QueryResult, []error) {
+	dirs := findProjects(lockfileNames)
+	if len(dirs) == 0 {
+		return nil, []error{fmt.Errorf("no lockfile found in %s", projectPath())}
+	}
+
+	results := make(map[string]*QueryResult)
+	var errs []error
+	for _, dir := range dirs {
+		result, queryErrs := query(dir)
+		for _, err := range queryErrs {
+			errs = append(errs, fmt.Errorf("%s: %w", dir, err))
+		}
+		if result != nil {
+			results[dir] = result
+		}
+	}
+
+	return results, errs
+}
@@ -0,0 +1,55 @@
+package pkgmanager
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFindProjects(t *testing.T) {
+	dir := t.TempDir()
+	for _, path := range []string{
+		"package-lock.json",
+		"a/yarn.lock",
+		"a/b/pnpm-lock.yaml",
+		"a/b/package-lock.json",
+		"node_modules/c/package-lock.json",
+		".cache/d/yarn.lock",
+	} {
+		path = filepath.Join(dir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(filepath.Join(dir, "a")); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	defer SetOptions(Options{})
+
+	for _, tt := range []struct {
+		path          string
+		lockfileNames []string
+		want          []string
+	}{
+		{"", npmLockfileNames, nil},
+		{"", []string{yarnLockfileName}, []string{"."}},
+		{dir, npmLockfileNames, []string{filepath.Join(dir, "a", "b"), dir}},
+		{dir, []string{yarnLockfileName}, []string{filepath.Join(dir, "a")}},
+		{dir, []string{pnpmLockfileName}, []string{filepath.Join(dir, "a", "b")}},
+	} {
+		SetOptions(Options{Path: tt.path})
+		if got := findProjects(tt.lockfileNames); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("findProjects(%v) with path %q = %v, want %v", tt.lockfileNames, tt.path, got, tt.want)
+		}
+	}
+}
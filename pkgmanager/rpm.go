@@ -1,102 +1,177 @@
 package pkgmanager
 
 import (
+	"encoding/hex"
 	"fmt"
 	"github.com/Hitachi/spirat/sysinfo"
-	"github.com/Hitachi/spirat/utils"
 	"github.com/package-url/packageurl-go"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
+	"time"
 )
 
-type rpm struct{}
-
-func (r *rpm) Query() (*QueryResult, []error) {
-	names, err := r.queryNames()
-	if err != nil {
-		return nil, []error{err}
-	}
-
-	versions, err := r.queryVersions()
-	if err != nil {
-		return nil, []error{err}
-	}
-
-	if len(names) != len(versions) {
-		return nil, []error{fmt.Errorf("names and versions should be the same length")}
-	}
+// Separators of the output of the rpm command.
+const (
+	rpmRecordSeparator   = "\x1e"
+	rpmFieldSeparator    = "\x1f"
+	rpmItemSeparator     = "\x1d"
+	rpmSubfieldSeparator = "\x1c"
+)
 
-	releases, err := r.queryReleases()
-	if err != nil {
-		return nil, []error{err}
-	}
+// rpmQueryTags are the tags with a single value queried with the rpm command.
+var rpmQueryTags = []string{
+	"NAME",
+	"EPOCH",
+	"VERSION",
+	"RELEASE",
+	"ARCH",
+	"LICENSE",
+	"URL",
+	"FILEDIGESTALGO",
+	"SOURCERPM",
+	"VENDOR",
+	"PACKAGER",
+	"BUILDHOST",
+	"BUILDTIME",
+	"RSAHEADER",
+	"DSAHEADER",
+	"SIGPGP",
+	"SIGGPG",
+}
 
-	if len(names) != len(releases) {
-		return nil, []error{fmt.Errorf("names and releases should be the same length")}
-	}
+// rpmSignatureTags are the tags that may hold the OpenPGP signature of a package, in order of preference. Signatures of
+// the header alone come first, as the others cover the payload that is no longer around once the package is installed.
+var rpmSignatureTags = []struct {
+	tag  int32
+	name string
+}{
+	{rpmTagRSAHeader, "RSAHEADER"},
+	{rpmTagDSAHeader, "DSAHEADER"},
+	{rpmTagSigPGP, "SIGPGP"},
+	{rpmTagSigGPG, "SIGGPG"},
+}
 
-	architectures, err := r.queryArchitectures()
-	if err != nil {
-		return nil, []error{err}
-	}
+type rpm struct{}
 
-	if len(names) != len(architectures) {
-		return nil, []error{fmt.Errorf("names and architectures should be the same length")}
-	}
+// rpmEntry is an installed package read from the rpm database or from the output of the rpm command.
+type rpmEntry struct {
+	name      string
+	epoch     string
+	version   string
+	release   string
+	arch      string
+	license   string
+	url       string
+	sourceRPM string
+	vendor    string
+	packager  string
+	buildHost string
+	// buildTime is the time the package was built in seconds since the Unix epoch, or 0 if unknown.
+	buildTime int64
+	// signature is the first OpenPGP signature packet in rpmSignatureTags, or nil if the package is unsigned.
+	signature    []byte
+	licensePaths []string
+	// paths are the paths of all files including directories, which are capabilities that other packages may require.
+	paths     []string
+	provides  []string
+	relations []rpmRelation
+	// recordedFiles are the files with the digests recorded at installation time. Configuration files, ghost files and
+	// files without digests, such as directories and symbolic links, are left out.
+	recordedFiles []*File
+	// digestErr is why the digests of the files cannot be verified, such as an unsupported digest algorithm.
+	digestErr error
+}
 
-	licenses, err := r.queryLicenses()
-	if err != nil {
-		return nil, []error{err}
+// evr returns the version of e in the `[epoch:]version-release` form that rpm compares.
+func (e *rpmEntry) evr() string {
+	evr := e.version + "-" + e.release
+	if e.epoch != "" {
+		evr = e.epoch + ":" + evr
 	}
+	return evr
+}
 
-	if len(names) != len(licenses) {
-		return nil, []error{fmt.Errorf("names and licenses should be the same length")}
-	}
+// id identifies e by its full version and architecture, as multiple versions of a package such as kernels and multiple
+// architectures of a library such as x86_64 and i686 can be installed at the same time.
+func (e *rpmEntry) id() PackageID {
+	return packageID(e.name, e.evr(), e.arch)
+}
 
-	urls, err := r.queryUrls()
-	if err != nil {
-		return nil, []error{err}
+func (r *rpm) Query() (*QueryResult, []error) {
+	entries, errs := r.readEntries()
+	if entries == nil && errs != nil {
+		return nil, errs
 	}
 
-	if len(names) != len(urls) {
-		return nil, []error{fmt.Errorf("names and urls should be the same length")}
-	}
+	osRelease := sysinfo.NewOSRelease(options.Root)
+	archives, archiveErrs := r.queryRepoArchives(osRelease, entries)
+	errs = append(errs, archiveErrs...)
 
-	osRelease := sysinfo.NewOSRelease()
 	pkgs := make(map[PackageID]*Package)
-	var errs []error
-	for i := 0; i < len(names); i++ {
-		name := names[i]
-		version := versions[i]
-		release := releases[i]
-		arch := architectures[i]
-		licenseFiles, licenseErrs := r.queryLicenseFiles(r.constructRpmName(name, version, release, arch))
+	recorded := make(map[PackageID][]*File)
+	for _, e := range entries {
+		licenseFiles, licenseErrs := r.readLicenseFiles(e.licensePaths)
 		if licenseErrs != nil {
 			errs = append(errs, licenseErrs...)
 		}
 
 		pkg := &Package{
-			ID:           packageID(name, version),
-			Name:         name,
-			Version:      version,
-			Licenses:     []*License{licenses[i]},
+			ID:           e.id(),
+			Name:         e.name,
+			Version:      e.evr(),
+			Architecture: e.arch,
+			Licenses:     []*License{newLicense(e.license)},
 			LicenseFiles: licenseFiles,
-			HomepageUrl:  urls[i],
-			Filename:     r.constructFilename(name, version, release, arch),
-			PackageURL: packageurl.NewPackageURL(
-				packageurl.TypeRPM,
-				osRelease.ID,
-				name,
-				version,
-				packageurl.Qualifiers{},
-				"",
-			),
+			HomepageUrl:  e.url,
+			Filename:     r.constructFilename(e.name, e.version, e.release, e.arch),
+			PackageURL:   r.purl(osRelease, e.name, e.epoch, e.version+"-"+e.release, e.arch),
+			Source:       r.sourcePackage(osRelease, e),
+			Supplier:     r.supplier(e),
+			BuildHost:    e.buildHost,
+			Signature:    &Signature{},
+		}
+		if archive, ok := archives[pkg.ID]; ok {
+			pkg.DownloadUrl = archive.url
+			pkg.SourceInfo = "acquired from " + archive.origin
+			if archive.checksum != nil {
+				pkg.Checksums = []*Checksum{archive.checksum}
+			}
+		}
+		if e.buildTime != 0 {
+			buildTime := time.Unix(e.buildTime, 0).UTC()
+			pkg.BuildTime = &buildTime
+		}
+		if e.signature != nil {
+			signature, err := parseOpenPGPSignature(e.signature)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to read the signature of %s: %w", e.name, err))
+			}
+			pkg.Signature = signature
+		}
+		pkgs[pkg.ID] = pkg
+
+		if options.Verify {
+			recorded[pkg.ID] = e.recordedFiles
+			if e.digestErr != nil {
+				errs = append(errs, fmt.Errorf("failed to verify the files of %s: %w", e.name, e.digestErr))
+			}
 		}
-		pkgs[packageID(name, version)] = pkg
 	}
 
-	queryResult := &QueryResult{Packages: pkgs}
+	if options.Verify {
+		verifications, verifyErrs := verifyFiles(recorded)
+		errs = append(errs, verifyErrs...)
+		for id, v := range verifications {
+			pkgs[id].Verification = v
+		}
+	}
+
+	queryResult := &QueryResult{
+		Packages:     pkgs,
+		Dependencies: r.queryDependencies(entries),
+	}
 
 	if len(errs) > 0 {
 		return queryResult, errs
@@ -105,85 +180,326 @@ func (r *rpm) Query() (*QueryResult, []error) {
 	return queryResult, nil
 }
 
-func (r *rpm) String() string {
-	return "rpm"
-}
+// purl returns the package URL of a binary or source package, e.g.
+// `pkg:rpm/fedora/curl@7.50.3-1.fc25?arch=x86_64&distro=fedora-25&epoch=1`. The version consists of the version and the
+// release, and the epoch goes to the `epoch` qualifier.
+func (r *rpm) purl(osRelease sysinfo.OSRelease, name, epoch, version, arch string) *packageurl.PackageURL {
+	qualifiers := map[string]string{
+		"arch":   arch,
+		"epoch":  epoch,
+		"distro": osRelease.Distro(),
+	}
+	for k, v := range qualifiers {
+		if v == "" {
+			delete(qualifiers, k)
+		}
+	}
 
-func (r *rpm) Available() bool {
-	return hasCommand("rpm")
+	return packageurl.NewPackageURL(
+		packageurl.TypeRPM,
+		osRelease.ID,
+		name,
+		version,
+		packageurl.QualifiersFromMap(qualifiers),
+		"",
+	)
 }
 
-func (r *rpm) queryNames() ([]string, error) {
-	cmd := exec.Command("rpm", "-q", "--all", "--qf", "%{NAME}\\n")
-	output, err := cmd.Output()
-	if err != nil {
-		return nil, err
+// sourcePackage returns the source package named by the SOURCERPM tag, e.g. `bash-5.1.8-6.el9.src.rpm`, or nil if the
+// tag is missing. The source package shares the epoch of the binary packages built from it.
+func (r *rpm) sourcePackage(osRelease sysinfo.OSRelease, e *rpmEntry) *SourcePackage {
+	nvr := strings.TrimSuffix(strings.TrimSuffix(e.sourceRPM, ".src.rpm"), ".nosrc.rpm")
+	i := strings.LastIndex(nvr, "-")
+	if i == -1 || nvr == e.sourceRPM {
+		return nil
+	}
+	j := strings.LastIndex(nvr[:i], "-")
+	if j == -1 {
+		return nil
+	}
+	name, version := nvr[:j], nvr[j+1:]
+
+	evr := version
+	if e.epoch != "" {
+		evr = e.epoch + ":" + version
 	}
 
-	return strings.Split(strings.TrimSpace(string(output)), "\n"), nil
+	return &SourcePackage{
+		ID:         packageID("src", name, evr),
+		Name:       name,
+		Version:    evr,
+		PackageURL: r.purl(osRelease, name, e.epoch, version, "src"),
+	}
 }
 
-func (r *rpm) queryVersions() ([]string, error) {
-	cmd := exec.Command("rpm", "-q", "--all", "--qf", "%{VERSION}\\n")
-	output, err := cmd.Output()
-	if err != nil {
-		return nil, err
+// supplier returns the vendor of e, or the packager if there is no vendor. A packager with an email address, e.g.
+// `John Doe <jdoe@example.com>`, is a person, and other packagers such as `Fedora Project` are organizations.
+func (r *rpm) supplier(e *rpmEntry) *Supplier {
+	switch {
+	case e.vendor != "":
+		return &Supplier{Name: e.vendor, Type: SupplierOrganization}
+	case strings.Contains(e.packager, "@"):
+		return &Supplier{Name: e.packager, Type: SupplierPerson}
+	case e.packager != "":
+		return &Supplier{Name: e.packager, Type: SupplierOrganization}
+	default:
+		return nil
 	}
+}
 
-	return strings.Split(strings.TrimSpace(string(output)), "\n"), nil
+// readEntries reads the rpm database without the rpm command if it is found in a known location, which does not depend
+// on the backends that the installed rpm command supports. Otherwise, the rpm command is used.
+func (r *rpm) readEntries() ([]*rpmEntry, []error) {
+	if db := findRpmDatabase(); db != nil {
+		return r.readDatabase(db)
+	}
+
+	return r.queryEntries()
 }
 
-func (r *rpm) queryReleases() ([]string, error) {
-	cmd := exec.Command("rpm", "-q", "--all", "--qf", "%{RELEASE}\\n")
-	output, err := cmd.Output()
+// readDatabase decodes the header blobs in db. A header that cannot be decoded is reported without failing the others.
+func (r *rpm) readDatabase(db *rpmDatabase) ([]*rpmEntry, []error) {
+	entries := []*rpmEntry{}
+	var errs []error
+	err := db.read(db.path, func(blob []byte) error {
+		h, err := parseRpmHeader(blob)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to read a package in %s: %w", db.path, err))
+			return nil
+		}
+
+		// Public keys imported into the database are stored as pseudo-packages named gpg-pubkey.
+		name := h.string(rpmTagName)
+		if name == "" || name == "gpg-pubkey" {
+			return nil
+		}
+
+		entries = append(entries, r.newEntry(h))
+		return nil
+	})
 	if err != nil {
-		return nil, err
+		errs = append(errs, fmt.Errorf("failed to read %s: %w", db.path, err))
+		if len(entries) == 0 {
+			return nil, errs
+		}
 	}
 
-	return strings.Split(strings.TrimSpace(string(output)), "\n"), nil
+	return entries, errs
 }
 
-func (r *rpm) queryArchitectures() ([]string, error) {
-	cmd := exec.Command("rpm", "-q", "--all", "--qf", "%{ARCH}\\n")
-	output, err := cmd.Output()
-	if err != nil {
-		return nil, err
+func (r *rpm) newEntry(h *rpmHeader) *rpmEntry {
+	e := &rpmEntry{
+		name:      h.string(rpmTagName),
+		version:   h.string(rpmTagVersion),
+		release:   h.string(rpmTagRelease),
+		arch:      h.string(rpmTagArch),
+		license:   h.string(rpmTagLicense),
+		url:       h.string(rpmTagURL),
+		sourceRPM: h.string(rpmTagSourceRPM),
+		vendor:    h.string(rpmTagVendor),
+		packager:  h.string(rpmTagPackager),
+		buildHost: h.string(rpmTagBuildHost),
+	}
+	if epoch, ok := h.int(rpmTagEpoch); ok {
+		e.epoch = strconv.FormatInt(epoch, 10)
+	}
+	e.buildTime, _ = h.int(rpmTagBuildTime)
+	for _, t := range rpmSignatureTags {
+		if e.signature = h.bin(t.tag); e.signature != nil {
+			break
+		}
 	}
 
-	return strings.Split(strings.TrimSpace(string(output)), "\n"), nil
+	algo, _ := h.int(rpmTagFileDigestAlgo)
+	algorithm, err := r.checksumAlgorithm(algo)
+	e.digestErr = err
+	r.addFiles(e, algorithm, h.paths(), h.strings(rpmTagFileDigests), h.ints(rpmTagFileFlags))
+
+	e.provides = h.strings(rpmTagProvideName)
+	for _, t := range rpmRelationTags {
+		r.addRelations(e, t.dependencyType, t.reverse, h.strings(t.tag))
+	}
+
+	return e
+}
+
+// addFiles sets the paths, the license paths and the recorded files of e from the file list of a package.
+func (r *rpm) addFiles(e *rpmEntry, algorithm ChecksumAlgorithm, paths, digests []string, flags []int64) {
+	e.paths = paths
+	for i, path := range paths {
+		var flag int64
+		if i < len(flags) {
+			flag = flags[i]
+		}
+
+		if flag&rpmFileLicense != 0 {
+			e.licensePaths = append(e.licensePaths, path)
+		}
+
+		if i < len(digests) && digests[i] != "" && algorithm != "" && flag&(rpmFileConfig|rpmFileGhost) == 0 {
+			e.recordedFiles = append(e.recordedFiles, &File{
+				Path:      path,
+				Checksums: []*Checksum{{Algorithm: algorithm, Value: digests[i]}},
+			})
+		}
+	}
 }
 
-func (r *rpm) queryUrls() ([]string, error) {
-	cmd := exec.Command("rpm", "-q", "--all", "--qf", "%{URL}\\n")
+// queryEntries queries all installed packages with a single invocation of the rpm command. Packages, fields and the
+// items of arrays are separated with ASCII control characters, which cannot appear in the values, so a value containing
+// a newline cannot misalign the output.
+func (r *rpm) queryEntries() ([]*rpmEntry, []error) {
+	// The groups of array tags queried after rpmQueryTags. The tags in a group have the same number of values.
+	arrays := [][]string{{"FILEFLAGS", "FILEDIGESTS", "FILENAMES"}, {"PROVIDENAME"}}
+	for _, t := range rpmRelationTags {
+		arrays = append(arrays, []string{t.name})
+	}
+
+	// Tags unknown to an older rpm command, e.g. RECOMMENDNAME before rpm 4.12, are left empty rather than failing the
+	// query.
+	known := r.queryKnownTags()
+	var queryFormat string
+	for _, tag := range rpmQueryTags {
+		if known == nil || known[tag] {
+			queryFormat += "%{" + tag + "}"
+		}
+		queryFormat += rpmFieldSeparator
+	}
+	for _, tags := range arrays {
+		if known == nil || known[tags[0]] {
+			queryFormat += "[%{" + strings.Join(tags, "}"+rpmSubfieldSeparator+"%{") + "}" + rpmItemSeparator + "]"
+		}
+		queryFormat += rpmFieldSeparator
+	}
+	queryFormat += rpmRecordSeparator
+
+	args := []string{"-q", "--all", "--qf", queryFormat}
+	if options.Root != "" {
+		args = append(args, "--root", options.Root)
+	}
+	cmd := exec.Command("rpm", args...)
 	output, err := cmd.Output()
 	if err != nil {
-		return nil, err
+		return nil, []error{err}
+	}
+
+	var entries []*rpmEntry
+	var errs []error
+	for _, record := range strings.Split(string(output), rpmRecordSeparator) {
+		if strings.TrimSpace(record) == "" {
+			continue
+		}
+
+		// Each field is followed by a separator, so the last field is empty.
+		fields := strings.Split(record, rpmFieldSeparator)
+		if len(fields) != len(rpmQueryTags)+len(arrays)+1 {
+			errs = append(errs, fmt.Errorf("unexpected output of rpm: %q", record))
+			continue
+		}
+
+		values := make(map[string]string)
+		for i, tag := range rpmQueryTags {
+			if fields[i] != "(none)" {
+				values[tag] = fields[i]
+			}
+		}
+
+		// items returns the values of the i-th group of arrays, with one slice per tag in the group.
+		items := func(i int) [][]string {
+			ret := make([][]string, len(arrays[i]))
+			for _, item := range strings.Split(fields[len(rpmQueryTags)+i], rpmItemSeparator) {
+				subfields := strings.Split(item, rpmSubfieldSeparator)
+				if len(subfields) != len(arrays[i]) {
+					continue
+				}
+				for j, v := range subfields {
+					ret[j] = append(ret[j], v)
+				}
+			}
+			return ret
+		}
+
+		// Public keys imported into the database are stored as pseudo-packages named gpg-pubkey.
+		if values["NAME"] == "gpg-pubkey" {
+			continue
+		}
+
+		e := &rpmEntry{
+			name:      values["NAME"],
+			epoch:     values["EPOCH"],
+			version:   values["VERSION"],
+			release:   values["RELEASE"],
+			arch:      values["ARCH"],
+			license:   values["LICENSE"],
+			url:       values["URL"],
+			sourceRPM: values["SOURCERPM"],
+			vendor:    values["VENDOR"],
+			packager:  values["PACKAGER"],
+			buildHost: values["BUILDHOST"],
+		}
+		e.buildTime, _ = strconv.ParseInt(values["BUILDTIME"], 10, 64)
+
+		// Binary tags are formatted in hexadecimal.
+		for _, t := range rpmSignatureTags {
+			if signature, err := hex.DecodeString(values[t.name]); err == nil && len(signature) > 0 {
+				e.signature = signature
+				break
+			}
+		}
+
+		files := items(0)
+		var flags []int64
+		for _, f := range files[0] {
+			flag, _ := strconv.ParseInt(f, 10, 64)
+			flags = append(flags, flag)
+		}
+
+		// Packages built before the tag was introduced have no value, which is treated as 0.
+		algo, _ := strconv.ParseInt(values["FILEDIGESTALGO"], 10, 64)
+		algorithm, err := r.checksumAlgorithm(algo)
+		e.digestErr = err
+		r.addFiles(e, algorithm, files[2], files[1], flags)
+
+		e.provides = items(1)[0]
+		for i, t := range rpmRelationTags {
+			r.addRelations(e, t.dependencyType, t.reverse, items(2 + i)[0])
+		}
+
+		entries = append(entries, e)
 	}
 
-	return strings.Split(strings.TrimSpace(string(output)), "\n"), nil
+	if len(entries) == 0 && len(errs) > 0 {
+		return nil, errs
+	}
+
+	return entries, errs
 }
 
-func (r *rpm) queryLicenses() ([]*License, error) {
-	cmd := exec.Command("rpm", "-q", "--all", "--qf", "%{LICENSE}\\n")
-	output, err := cmd.Output()
+// queryKnownTags returns the names of the tags known to the rpm command, or nil if they cannot be queried.
+func (r *rpm) queryKnownTags() map[string]bool {
+	output, err := exec.Command("rpm", "--querytags").Output()
 	if err != nil {
-		return nil, err
+		return nil
 	}
 
-	names := strings.Split(strings.TrimSpace(string(output)), "\n")
+	known := make(map[string]bool)
+	for _, tag := range strings.Fields(string(output)) {
+		known[tag] = true
+	}
 
-	return utils.Map(names, func(name string) *License {
-		return &License{Name: name}
-	}), nil
+	return known
 }
 
-func (r *rpm) queryLicenseFiles(rpmName string) ([]*LicenseFile, []error) {
-	var errs []error
+func (r *rpm) String() string {
+	return "rpm"
+}
 
-	licensePaths, err := r.queryLicensePaths(rpmName)
-	if err != nil {
-		errs = append(errs, err)
-	}
+func (r *rpm) Available() bool {
+	return findRpmDatabase() != nil || hasCommand("rpm")
+}
+
+func (r *rpm) readLicenseFiles(licensePaths []string) ([]*LicenseFile, []error) {
+	var errs []error
 
 	var licenseFiles []*LicenseFile
 	for _, licensePath := range licensePaths {
@@ -206,14 +522,25 @@ func (r *rpm) queryLicenseFiles(rpmName string) ([]*LicenseFile, []error) {
 	return licenseFiles, nil
 }
 
-func (r *rpm) queryLicensePaths(name string) ([]string, error) {
-	cmd := exec.Command("rpm", "-q", name, "-L")
-	output, err := cmd.Output()
-	if err != nil {
-		return nil, err
+// checksumAlgorithm converts the value of the FILEDIGESTALGO tag, which holds an OpenPGP hash algorithm ID, into a
+// checksum algorithm. Packages built before the tag was introduced, which have no value, use MD5.
+func (r *rpm) checksumAlgorithm(algo int64) (ChecksumAlgorithm, error) {
+	switch algo {
+	case 0, 1:
+		return ChecksumMD5, nil
+	case 2:
+		return ChecksumSHA1, nil
+	case 8:
+		return ChecksumSHA256, nil
+	case 9:
+		return ChecksumSHA384, nil
+	case 10:
+		return ChecksumSHA512, nil
+	case 11:
+		return ChecksumSHA224, nil
+	default:
+		return "", fmt.Errorf("unsupported file digest algorithm %d", algo)
 	}
-
-	return strings.Split(strings.TrimSpace(string(output)), "\n"), nil
 }
 
 func (r *rpm) constructRpmName(name, version, release, arch string) string {
@@ -225,6 +552,7 @@ func (r *rpm) constructFilename(name, version, release, arch string) string {
 }
 
 func (r *rpm) readLicenseText(path string) (string, error) {
+	path = rootPath(path)
 	if _, err := os.Stat(path); os.IsNotExist(err) {
 		// Return no error if the file does not exist.
 		return "", nil
@@ -0,0 +1,263 @@
+package pkgmanager
+
+import (
+	"strings"
+	"unicode"
+)
+
+// rpmRelationTags are the tags of the capabilities that become dependencies, in the order of their strength. Supplements
+// and Enhances are reverse relations, which are declared by the package that is depended on.
+var rpmRelationTags = []struct {
+	tag            int32
+	name           string
+	dependencyType DependencyType
+	reverse        bool
+}{
+	{rpmTagRequireName, "REQUIRENAME", DependencyTypeDependsOn, false},
+	{rpmTagRecommendName, "RECOMMENDNAME", DependencyTypeRecommends, false},
+	{rpmTagSuggestName, "SUGGESTNAME", DependencyTypeSuggests, false},
+	{rpmTagSupplementName, "SUPPLEMENTNAME", DependencyTypeWeak, true},
+	{rpmTagEnhanceName, "ENHANCENAME", DependencyTypeWeak, true},
+}
+
+// rpmRelation is a capability named in one of rpmRelationTags.
+type rpmRelation struct {
+	capability     string
+	dependencyType DependencyType
+	reverse        bool
+}
+
+// rpmIndex looks up installed packages by the capabilities they provide, which include their names, the names in
+// Provides such as sonames like `libc.so.6()(64bit)`, and the paths of their files.
+type rpmIndex struct {
+	providers map[string][]*rpmEntry
+}
+
+func (r *rpm) queryDependencies(entries []*rpmEntry) []*PackageDependency {
+	index := r.newIndex(entries)
+
+	var deps []*PackageDependency
+	seen := make(map[PackageDependency]struct{})
+	for _, e := range entries {
+		for _, relation := range e.relations {
+			// Version constraints are not checked as rpm already enforced them when the packages were installed.
+			for _, provider := range index.resolve(relation.capability, e) {
+				dep := PackageDependency{
+					RequiringPackageID: e.id(),
+					RequiredPackageID:  provider.id(),
+					DependencyType:     relation.dependencyType,
+				}
+				if relation.reverse {
+					dep.RequiringPackageID, dep.RequiredPackageID = dep.RequiredPackageID, dep.RequiringPackageID
+				}
+
+				if _, ok := seen[dep]; !ok && dep.RequiringPackageID != dep.RequiredPackageID {
+					seen[dep] = struct{}{}
+					deps = append(deps, &dep)
+				}
+			}
+		}
+	}
+
+	return deps
+}
+
+// addRelations adds the capabilities named in a relation tag to e. Capabilities that rpm itself provides, such as
+// `rpmlib(PayloadIsZstd)`, are dropped.
+func (r *rpm) addRelations(e *rpmEntry, dependencyType DependencyType, reverse bool, capabilities []string) {
+	for _, c := range capabilities {
+		if c == "" || strings.HasPrefix(c, "rpmlib(") {
+			continue
+		}
+		e.relations = append(e.relations, rpmRelation{capability: c, dependencyType: dependencyType, reverse: reverse})
+	}
+}
+
+func (r *rpm) newIndex(entries []*rpmEntry) *rpmIndex {
+	index := &rpmIndex{providers: make(map[string][]*rpmEntry)}
+
+	add := func(capability string, e *rpmEntry) {
+		providers := index.providers[capability]
+		if len(providers) == 0 || providers[len(providers)-1] != e {
+			index.providers[capability] = append(providers, e)
+		}
+	}
+
+	for _, e := range entries {
+		add(e.name, e)
+		for _, p := range e.provides {
+			add(p, e)
+		}
+		for _, p := range e.paths {
+			add(p, e)
+		}
+	}
+
+	return index
+}
+
+// resolve returns the installed packages satisfying a capability on behalf of the requiring package. A rich dependency
+// such as `(pipewire-pulseaudio if pipewire)` may resolve to multiple packages.
+func (i *rpmIndex) resolve(capability string, requiring *rpmEntry) []*rpmEntry {
+	if strings.HasPrefix(capability, "(") {
+		p := &rpmRichParser{tokens: tokenizeRpmRich(capability), index: i, requiring: requiring}
+		found, _ := p.parse()
+		return found
+	}
+
+	if found := i.pick(i.providers[capability], requiring); found != nil {
+		return []*rpmEntry{found}
+	}
+
+	return nil
+}
+
+// pick returns the requiring package itself if it is one of the candidates, or the candidate whose architecture matches
+// the requiring package, so that the i686 copy of a multilib package depends on other i686 packages.
+func (i *rpmIndex) pick(candidates []*rpmEntry, requiring *rpmEntry) *rpmEntry {
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	for _, c := range candidates {
+		if c == requiring {
+			return c
+		}
+	}
+
+	for _, arch := range []string{requiring.arch, "noarch"} {
+		for _, c := range candidates {
+			if c.arch == arch {
+				return c
+			}
+		}
+	}
+
+	return candidates[0]
+}
+
+// tokenizeRpmRich splits a rich dependency into the parentheses of its groups and words. Parentheses that follow a name
+// are part of the capability, as in `python3dist(setuptools)` and `libc.so.6()(64bit)`.
+func tokenizeRpmRich(capability string) []string {
+	var tokens []string
+	var word strings.Builder
+	// depth is the number of parentheses opened in the current word.
+	depth := 0
+	flush := func() {
+		if word.Len() > 0 {
+			tokens = append(tokens, word.String())
+			word.Reset()
+		}
+	}
+
+	for _, c := range capability {
+		switch {
+		case c == '(' && word.Len() == 0:
+			tokens = append(tokens, "(")
+		case c == '(':
+			depth++
+			word.WriteRune(c)
+		case c == ')' && depth > 0:
+			depth--
+			word.WriteRune(c)
+		case c == ')':
+			flush()
+			tokens = append(tokens, ")")
+		case unicode.IsSpace(c) && depth == 0:
+			flush()
+		default:
+			word.WriteRune(c)
+		}
+	}
+	flush()
+
+	return tokens
+}
+
+// rpmRichParser resolves a rich dependency, which is a parenthesized expression of capabilities joined by one of the
+// operators `and`, `or`, `if`, `unless`, `else`, `with` and `without`, against the installed packages.
+type rpmRichParser struct {
+	tokens    []string
+	pos       int
+	index     *rpmIndex
+	requiring *rpmEntry
+}
+
+// parse resolves the operand at the current position. It returns the packages that the operand depends on and whether
+// the operand is satisfied by the installed packages.
+func (p *rpmRichParser) parse() ([]*rpmEntry, bool) {
+	if p.pos == len(p.tokens) {
+		return nil, false
+	}
+
+	if p.tokens[p.pos] != "(" {
+		// A simple capability may be followed by a version constraint, e.g. `foo >= 1.0`.
+		capability := p.tokens[p.pos]
+		p.pos++
+		if p.pos+1 < len(p.tokens) && strings.Trim(p.tokens[p.pos], "<=>") == "" {
+			p.pos += 2
+		}
+
+		found := p.index.resolve(capability, p.requiring)
+		return found, len(found) > 0
+	}
+
+	p.pos++
+	first, ok := p.parse()
+	operands := [][]*rpmEntry{first}
+	satisfied := []bool{ok}
+	var op string
+	for p.pos < len(p.tokens) && p.tokens[p.pos] != ")" {
+		if op == "" || p.tokens[p.pos] == "else" {
+			op += p.tokens[p.pos]
+		}
+		p.pos++
+		found, ok := p.parse()
+		operands = append(operands, found)
+		satisfied = append(satisfied, ok)
+	}
+	p.pos++
+
+	if len(operands) < 2 {
+		return operands[0], satisfied[0]
+	}
+
+	switch op {
+	case "and", "with":
+		var all []*rpmEntry
+		ok := true
+		for i, o := range operands {
+			all = append(all, o...)
+			ok = ok && satisfied[i]
+		}
+		return all, ok
+	case "or":
+		for i, o := range operands {
+			if satisfied[i] {
+				return o, true
+			}
+		}
+		return nil, false
+	case "if", "ifelse":
+		// `A if B else C` depends on A if B is installed, and on C otherwise.
+		if satisfied[1] {
+			return operands[0], satisfied[0]
+		}
+		if len(operands) > 2 {
+			return operands[2], satisfied[2]
+		}
+		return nil, true
+	case "unless", "unlesselse":
+		// `A unless B else C` depends on A if B is not installed, and on C otherwise.
+		if !satisfied[1] {
+			return operands[0], satisfied[0]
+		}
+		if len(operands) > 2 {
+			return operands[2], satisfied[2]
+		}
+		return nil, true
+	default:
+		// `A without B` depends on A.
+		return operands[0], satisfied[0]
+	}
+}
@@ -0,0 +1,67 @@
+package pkgmanager
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenizeRpmRich(t *testing.T) {
+	tests := []struct {
+		capability string
+		want       []string
+	}{
+		{"(foo if bar)", []string{"(", "foo", "if", "bar", ")"}},
+		{"((a or b) and c)", []string{"(", "(", "a", "or", "b", ")", "and", "c", ")"}},
+		{
+			"(python3dist(setuptools) >= 40 with python3dist(setuptools) < 60)",
+			[]string{"(", "python3dist(setuptools)", ">=", "40", "with", "python3dist(setuptools)", "<", "60", ")"},
+		},
+		{"(libc.so.6()(64bit) if python3)", []string{"(", "libc.so.6()(64bit)", "if", "python3", ")"}},
+		{"(perl(Foo::Bar) or (pkgconfig(glib-2.0) and font(:lang=en)))", []string{
+			"(", "perl(Foo::Bar)", "or", "(", "pkgconfig(glib-2.0)", "and", "font(:lang=en)", ")", ")",
+		}},
+	}
+
+	for _, tt := range tests {
+		if got := tokenizeRpmRich(tt.capability); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("tokenizeRpmRich(%q) = %q, want %q", tt.capability, got, tt.want)
+		}
+	}
+}
+
+func TestRpmIndexResolve(t *testing.T) {
+	setuptools := &rpmEntry{name: "python3-setuptools", arch: "noarch", provides: []string{"python3dist(setuptools)"}}
+	glibc := &rpmEntry{name: "glibc", arch: "x86_64", provides: []string{"libc.so.6()(64bit)"}}
+	glibc32 := &rpmEntry{name: "glibc", arch: "i686", provides: []string{"libc.so.6"}}
+	python := &rpmEntry{name: "python3", arch: "x86_64"}
+	requiring := &rpmEntry{name: "foo", arch: "x86_64"}
+	index := (&rpm{}).newIndex([]*rpmEntry{setuptools, glibc, glibc32, python, requiring})
+
+	tests := []struct {
+		capability string
+		want       []*rpmEntry
+	}{
+		{"python3dist(setuptools)", []*rpmEntry{setuptools}},
+		{"(python3dist(setuptools) >= 40 with python3dist(setuptools) < 60)", []*rpmEntry{setuptools, setuptools}},
+		{"(libc.so.6()(64bit) if python3)", []*rpmEntry{glibc}},
+		{"(libc.so.6()(64bit) unless python3)", nil},
+		{"(missing or libc.so.6)", []*rpmEntry{glibc32}},
+		{"(missing(1) and python3)", []*rpmEntry{python}},
+		{"(python3 if missing else libc.so.6()(64bit))", []*rpmEntry{glibc}},
+		{"(python3 without libc.so.6)", []*rpmEntry{python}},
+	}
+
+	for _, tt := range tests {
+		if got := index.resolve(tt.capability, requiring); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("resolve(%q) = %v, want %v", tt.capability, rpmEntryNames(got), rpmEntryNames(tt.want))
+		}
+	}
+}
+
+func rpmEntryNames(entries []*rpmEntry) []string {
+	var ret []string
+	for _, e := range entries {
+		ret = append(ret, e.name+"."+e.arch)
+	}
+	return ret
+}
@@ -0,0 +1,218 @@
+package pkgmanager
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"path"
+)
+
+// Tags of the rpm header. See rpmtag.h of rpm for the complete list.
+const (
+	rpmTagSigPGP         = 259
+	rpmTagSigGPG         = 262
+	rpmTagDSAHeader      = 267
+	rpmTagRSAHeader      = 268
+	rpmTagName           = 1000
+	rpmTagVersion        = 1001
+	rpmTagRelease        = 1002
+	rpmTagEpoch          = 1003
+	rpmTagBuildTime      = 1006
+	rpmTagBuildHost      = 1007
+	rpmTagVendor         = 1011
+	rpmTagLicense        = 1014
+	rpmTagPackager       = 1015
+	rpmTagURL            = 1020
+	rpmTagArch           = 1022
+	rpmTagFileFlags      = 1037
+	rpmTagFileDigests    = 1035
+	rpmTagSourceRPM      = 1044
+	rpmTagProvideName    = 1047
+	rpmTagRequireName    = 1049
+	rpmTagDirIndexes     = 1116
+	rpmTagBaseNames      = 1117
+	rpmTagDirNames       = 1118
+	rpmTagFileDigestAlgo = 5011
+	rpmTagRecommendName  = 5046
+	rpmTagSuggestName    = 5049
+	rpmTagSupplementName = 5052
+	rpmTagEnhanceName    = 5055
+)
+
+// Types of the values in the rpm header.
+const (
+	rpmTypeInt8        = 2
+	rpmTypeInt16       = 3
+	rpmTypeInt32       = 4
+	rpmTypeInt64       = 5
+	rpmTypeString      = 6
+	rpmTypeBin         = 7
+	rpmTypeStringArray = 8
+	rpmTypeI18NString  = 9
+)
+
+// Flags of the FILEFLAGS tag.
+const (
+	rpmFileConfig  = 1 << 0
+	rpmFileGhost   = 1 << 6
+	rpmFileLicense = 1 << 7
+)
+
+type rpmTagEntry struct {
+	typ   uint32
+	count uint32
+	data  []byte
+}
+
+// rpmHeader is a header blob as stored in the rpm database, without the lead and the header magic of package files.
+type rpmHeader struct {
+	tags map[int32]*rpmTagEntry
+}
+
+// parseRpmHeader decodes a header blob, which consists of the number of index entries, the size of the data store, the
+// index entries and the data store. All numbers are big-endian.
+func parseRpmHeader(blob []byte) (*rpmHeader, error) {
+	if len(blob) < 8 {
+		return nil, fmt.Errorf("rpm header too short")
+	}
+
+	il := binary.BigEndian.Uint32(blob[0:])
+	dl := binary.BigEndian.Uint32(blob[4:])
+	if uint64(il)*16+uint64(dl)+8 > uint64(len(blob)) {
+		return nil, fmt.Errorf("rpm header truncated: %d entries and %d bytes of data in %d bytes", il, dl, len(blob))
+	}
+
+	store := blob[8+il*16 : 8+il*16+dl]
+	h := &rpmHeader{tags: make(map[int32]*rpmTagEntry, il)}
+	for i := uint32(0); i < il; i++ {
+		e := blob[8+i*16:]
+		tag := int32(binary.BigEndian.Uint32(e[0:]))
+		typ := binary.BigEndian.Uint32(e[4:])
+		offset := binary.BigEndian.Uint32(e[8:])
+		count := binary.BigEndian.Uint32(e[12:])
+		if offset > dl {
+			return nil, fmt.Errorf("rpm header tag %d out of range", tag)
+		}
+
+		// Entries added after installation, e.g. INSTALLTIME, follow the immutable region, so later entries win.
+		h.tags[tag] = &rpmTagEntry{typ: typ, count: count, data: store[offset:]}
+	}
+
+	return h, nil
+}
+
+// string returns the value of a STRING tag, or the first value of a STRING_ARRAY or I18NSTRING tag.
+func (h *rpmHeader) string(tag int32) string {
+	if s := h.strings(tag); len(s) > 0 {
+		return s[0]
+	}
+	return ""
+}
+
+func (h *rpmHeader) strings(tag int32) []string {
+	e, ok := h.tags[tag]
+	if !ok {
+		return nil
+	}
+
+	count := e.count
+	switch e.typ {
+	case rpmTypeString:
+		count = 1
+	case rpmTypeStringArray, rpmTypeI18NString:
+	default:
+		return nil
+	}
+	// Each string takes at least its terminating NUL, so a larger count is corrupt.
+	if uint64(count) > uint64(len(e.data)) {
+		return nil
+	}
+
+	ret := make([]string, 0, count)
+	data := e.data
+	for i := uint32(0); i < count; i++ {
+		end := bytes.IndexByte(data, 0)
+		if end == -1 {
+			break
+		}
+		ret = append(ret, string(data[:end]))
+		data = data[end+1:]
+	}
+
+	return ret
+}
+
+// ints returns the values of an integer tag of any size.
+func (h *rpmHeader) ints(tag int32) []int64 {
+	e, ok := h.tags[tag]
+	if !ok {
+		return nil
+	}
+
+	var size uint32
+	switch e.typ {
+	case rpmTypeInt8:
+		size = 1
+	case rpmTypeInt16:
+		size = 2
+	case rpmTypeInt32:
+		size = 4
+	case rpmTypeInt64:
+		size = 8
+	default:
+		return nil
+	}
+	if uint64(e.count)*uint64(size) > uint64(len(e.data)) {
+		return nil
+	}
+
+	ret := make([]int64, e.count)
+	for i := range ret {
+		b := e.data[uint32(i)*size:]
+		switch size {
+		case 1:
+			ret[i] = int64(b[0])
+		case 2:
+			ret[i] = int64(binary.BigEndian.Uint16(b))
+		case 4:
+			ret[i] = int64(binary.BigEndian.Uint32(b))
+		case 8:
+			ret[i] = int64(binary.BigEndian.Uint64(b))
+		}
+	}
+
+	return ret
+}
+
+// int returns the first value of an integer tag and whether the tag exists.
+func (h *rpmHeader) int(tag int32) (int64, bool) {
+	if v := h.ints(tag); len(v) > 0 {
+		return v[0], true
+	}
+	return 0, false
+}
+
+func (h *rpmHeader) bin(tag int32) []byte {
+	e, ok := h.tags[tag]
+	if !ok || e.typ != rpmTypeBin || e.count > uint32(len(e.data)) {
+		return nil
+	}
+	return e.data[:e.count]
+}
+
+// paths returns the absolute paths of the files, which are stored as base names and indexes into the directory names.
+func (h *rpmHeader) paths() []string {
+	baseNames := h.strings(rpmTagBaseNames)
+	dirNames := h.strings(rpmTagDirNames)
+	dirIndexes := h.ints(rpmTagDirIndexes)
+
+	ret := make([]string, 0, len(baseNames))
+	for i, base := range baseNames {
+		if i >= len(dirIndexes) || dirIndexes[i] >= int64(len(dirNames)) {
+			break
+		}
+		ret = append(ret, path.Join(dirNames[dirIndexes[i]], base))
+	}
+
+	return ret
+}
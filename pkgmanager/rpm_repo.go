@@ -0,0 +1,644 @@
+package pkgmanager
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"github.com/Hitachi/spirat/sysinfo"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	dnfHistoryPath = "/var/lib/dnf/history.sqlite"
+	yumdbDirPath   = "/var/lib/yum/yumdb"
+)
+
+// rpmRepoConfigDirs hold the `.repo` files of dnf, yum and zypper.
+var rpmRepoConfigDirs = []string{"/etc/yum.repos.d", "/etc/distro.repos.d", "/etc/zypp/repos.d"}
+
+// rpmRepoVarsDirs hold the files that define custom variables of dnf and yum, each named after its variable.
+var rpmRepoVarsDirs = []string{"/etc/dnf/vars", "/etc/yum/vars"}
+
+// rpmRepoCaches are the repomd.xml files of the metadata cached by dnf, yum and zypper. depth is the number of
+// directories between the directory named after the repository and repomd.xml. dnf appends a hash to the repository ID.
+var rpmRepoCaches = []struct {
+	pattern  string
+	depth    int
+	trimHash bool
+}{
+	{"/var/cache/dnf/*/repodata/repomd.xml", 1, true},
+	{"/var/cache/libdnf5/*/repodata/repomd.xml", 1, true},
+	{"/var/cache/yum/*/*/*/repomd.xml", 0, false},
+	{"/var/cache/zypp/raw/*/repodata/repomd.xml", 1, false},
+}
+
+// dnfInstallActions are the actions of a transaction item in the history of dnf that leave the package installed, which
+// are Install, Downgrade, Obsolete, Upgrade and Reinstall.
+var dnfInstallActions = map[int64]bool{1: true, 2: true, 4: true, 6: true, 9: true}
+
+// dnfStateDone is the state of a transaction item that completed successfully.
+const dnfStateDone = 1
+
+// rpmRepoChecksumTypes maps the checksum types of repository metadata to the algorithms. `sha` is the old name of SHA1.
+var rpmRepoChecksumTypes = map[string]ChecksumAlgorithm{
+	"md5":    ChecksumMD5,
+	"sha":    ChecksumSHA1,
+	"sha1":   ChecksumSHA1,
+	"sha256": ChecksumSHA256,
+	"sha512": ChecksumSHA512,
+}
+
+var rpmRepoVarPattern = regexp.MustCompile(`\$(\w+)|\$\{(\w+)\}`)
+
+// rpmRepo is a repository configured for or cached by dnf, yum or zypper.
+type rpmRepo struct {
+	id string
+	// baseURL is the URL to which the locations of packages are relative, or empty if the repository is only reachable
+	// through mirrors that are not known offline.
+	baseURL string
+	// repomd is the path of the cached repomd.xml, or empty if the metadata is not cached.
+	repomd string
+}
+
+func (r *rpmRepo) origin() string {
+	if r.baseURL == "" {
+		return "repository " + r.id
+	}
+	return fmt.Sprintf("repository %s at %s", r.id, r.baseURL)
+}
+
+// rpmArchive is the package file of an installed package in the repository it was installed from.
+type rpmArchive struct {
+	// url is the URL of the package file, or empty if it is not known offline.
+	url      string
+	checksum *Checksum
+	origin   string
+}
+
+// rpmRepoPackage is a package listed in the primary metadata of a repository.
+type rpmRepoPackage struct {
+	Name    string `xml:"name"`
+	Arch    string `xml:"arch"`
+	Version struct {
+		Epoch string `xml:"epoch,attr"`
+		Ver   string `xml:"ver,attr"`
+		Rel   string `xml:"rel,attr"`
+	} `xml:"version"`
+	Checksum struct {
+		Type  string `xml:"type,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"checksum"`
+	Location struct {
+		Href string `xml:"href,attr"`
+		Base string `xml:"base,attr"`
+	} `xml:"location"`
+}
+
+// queryRepoArchives finds the repository each installed package came from, which is done offline. dnf records the
+// repository of each installation in its history database and yum in the yumdb. The package file is then looked up in
+// the cached metadata of that repository. zypper records neither, so the first cached repository that lists the same
+// name, version and architecture as an installed package wins.
+func (r *rpm) queryRepoArchives(osRelease sysinfo.OSRelease, entries []*rpmEntry) (map[PackageID]*rpmArchive, []error) {
+	vars := map[string]string{"releasever": osRelease.VersionID}
+	if arch, baseArch := rpmBaseArch(entries); arch != "" {
+		vars["arch"] = arch
+		vars["basearch"] = baseArch
+	}
+
+	wanted := make(map[string]*rpmEntry)
+	for _, e := range entries {
+		wanted[r.repoKey(e.name, e.epoch, e.version, e.release, e.arch)] = e
+	}
+
+	repoIDs, releasever, errs := r.readDnfHistory(wanted)
+	if releasever != "" {
+		vars["releasever"] = releasever
+	}
+	for id, repoID := range r.readYumdb(entries) {
+		if _, ok := repoIDs[id]; !ok {
+			repoIDs[id] = repoID
+		}
+	}
+
+	repos := r.findRepos(vars)
+
+	ret := make(map[PackageID]*rpmArchive)
+	for _, repo := range repos {
+		if repo.repomd == "" {
+			continue
+		}
+
+		err := r.readRepoPrimary(repo.repomd, func(p *rpmRepoPackage) {
+			e, ok := wanted[r.repoKey(p.Name, p.Version.Epoch, p.Version.Ver, p.Version.Rel, p.Arch)]
+			if !ok {
+				return
+			}
+			if _, ok := ret[e.id()]; ok {
+				return
+			}
+			if repoID, ok := repoIDs[e.id()]; ok && repoID != repo.id {
+				return
+			}
+
+			archive := &rpmArchive{origin: repo.origin()}
+			base := repo.baseURL
+			if p.Location.Base != "" {
+				base = p.Location.Base
+			}
+			if base != "" && p.Location.Href != "" {
+				archive.url = strings.TrimSuffix(base, "/") + "/" + p.Location.Href
+			}
+			if algorithm, ok := rpmRepoChecksumTypes[p.Checksum.Type]; ok {
+				archive.checksum = &Checksum{Algorithm: algorithm, Value: strings.TrimSpace(p.Checksum.Value)}
+			}
+			ret[e.id()] = archive
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to read the metadata of repository %s: %v", repo.id, err))
+		}
+	}
+
+	// Packages whose repository is known but whose metadata is not cached still get the repository.
+	byID := make(map[string]*rpmRepo)
+	for _, repo := range repos {
+		byID[repo.id] = repo
+	}
+	for id, repoID := range repoIDs {
+		if _, ok := ret[id]; ok {
+			continue
+		}
+		if repo, ok := byID[repoID]; ok {
+			ret[id] = &rpmArchive{origin: repo.origin()}
+		} else {
+			ret[id] = &rpmArchive{origin: "repository " + repoID}
+		}
+	}
+
+	return ret, errs
+}
+
+// repoKey identifies a package in the history of dnf and in repository metadata, where a missing epoch is 0.
+func (r *rpm) repoKey(name, epoch, version, release, arch string) string {
+	if epoch == "" {
+		epoch = "0"
+	}
+	return name + " " + epoch + ":" + version + "-" + release + " " + arch
+}
+
+// readDnfHistory returns the repositories that the installed packages were last installed from according to the history
+// of dnf, and the release version of the last transaction, which the URLs of repositories may depend on.
+func (r *rpm) readDnfHistory(wanted map[string]*rpmEntry) (map[PackageID]string, string, []error) {
+	ret := make(map[PackageID]string)
+	db, err := openSqlite(rootPath(dnfHistoryPath))
+	if os.IsNotExist(err) {
+		return ret, "", nil
+	}
+	if err != nil {
+		return ret, "", []error{fmt.Errorf("failed to read %s: %v", dnfHistoryPath, err)}
+	}
+	defer db.close()
+
+	repos := make(map[int64]string)
+	items := make(map[int64]*rpmEntry)
+	itemRepos := make(map[int64]string)
+	var releasever string
+	err = db.readTable("repo", func(row sqliteRow) error {
+		repos[row.int("id")] = row.string("repoid")
+		return nil
+	})
+	if err == nil {
+		err = db.readTable("rpm", func(row sqliteRow) error {
+			key := r.repoKey(row.string("name"), fmt.Sprint(row.int("epoch")), row.string("version"),
+				row.string("release"), row.string("arch"))
+			if e, ok := wanted[key]; ok {
+				items[row.int("item_id")] = e
+			}
+			return nil
+		})
+	}
+	if err == nil {
+		// Rows are read in the order of their IDs, so later transactions override earlier ones.
+		err = db.readTable("trans_item", func(row sqliteRow) error {
+			if dnfInstallActions[row.int("action")] && row.int("state") == dnfStateDone {
+				itemRepos[row.int("item_id")] = repos[row.int("repo_id")]
+			}
+			return nil
+		})
+	}
+	if err == nil {
+		err = db.readTable("trans", func(row sqliteRow) error {
+			if v := row.string("releasever"); v != "" {
+				releasever = v
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		return ret, "", []error{fmt.Errorf("failed to read %s: %v", dnfHistoryPath, err)}
+	}
+
+	for item, repoID := range itemRepos {
+		// Packages installed from a local file are recorded in pseudo repositories such as `@commandline`.
+		if e, ok := items[item]; ok && repoID != "" && !strings.HasPrefix(repoID, "@") {
+			ret[e.id()] = repoID
+		}
+	}
+
+	return ret, releasever, nil
+}
+
+// readYumdb returns the repositories that the installed packages were installed from according to the yumdb, which has
+// a directory for each package named `<checksum>-<name>-<version>-<release>-<arch>` with the repository in `from_repo`.
+func (r *rpm) readYumdb(entries []*rpmEntry) map[PackageID]string {
+	wanted := make(map[string]*rpmEntry)
+	for _, e := range entries {
+		wanted[r.constructRpmName(e.name, e.version, e.release, e.arch)] = e
+	}
+
+	ret := make(map[PackageID]string)
+	dirs, _ := filepath.Glob(filepath.Join(rootPath(yumdbDirPath), "*", "*"))
+	for _, dir := range dirs {
+		_, nvra, ok := strings.Cut(filepath.Base(dir), "-")
+		if !ok {
+			continue
+		}
+		e, ok := wanted[nvra]
+		if !ok {
+			continue
+		}
+
+		repoID, err := os.ReadFile(filepath.Join(dir, "from_repo"))
+		if id := strings.TrimSpace(string(repoID)); err == nil && id != "" && !strings.HasPrefix(id, "@") {
+			ret[e.id()] = id
+		}
+	}
+
+	return ret
+}
+
+// findRepos returns the configured repositories followed by the cached repositories in a stable order. A repository
+// that is both configured and cached appears once with the cached metadata.
+func (r *rpm) findRepos(vars map[string]string) []*rpmRepo {
+	for _, dir := range rpmRepoVarsDirs {
+		paths, _ := filepath.Glob(filepath.Join(rootPath(dir), "*"))
+		for _, path := range paths {
+			if value, err := os.ReadFile(path); err == nil {
+				vars[filepath.Base(path)] = strings.TrimSpace(string(value))
+			}
+		}
+	}
+	expand := func(s string) string {
+		return rpmRepoVarPattern.ReplaceAllStringFunc(s, func(v string) string {
+			name := strings.Trim(v, "${}")
+			if value, ok := vars[name]; ok {
+				return value
+			}
+			return v
+		})
+	}
+
+	var repos []*rpmRepo
+	byID := make(map[string]*rpmRepo)
+	for _, dir := range rpmRepoConfigDirs {
+		paths, _ := filepath.Glob(filepath.Join(rootPath(dir), "*.repo"))
+		sort.Strings(paths)
+		for _, path := range paths {
+			for id, baseURL := range r.readRepoConfig(path) {
+				if _, ok := byID[id]; ok {
+					continue
+				}
+				repo := &rpmRepo{id: id, baseURL: expand(baseURL)}
+				byID[id] = repo
+				repos = append(repos, repo)
+			}
+		}
+	}
+	sort.SliceStable(repos, func(i, j int) bool { return repos[i].id < repos[j].id })
+
+	for _, cache := range rpmRepoCaches {
+		paths, _ := filepath.Glob(rootPath(cache.pattern))
+		sort.Strings(paths)
+		for _, path := range paths {
+			dir := filepath.Dir(path)
+			for i := 0; i < cache.depth; i++ {
+				dir = filepath.Dir(dir)
+			}
+			id := filepath.Base(dir)
+			if cache.trimHash {
+				if i := strings.LastIndex(id, "-"); i > 0 {
+					id = id[:i]
+				}
+			}
+
+			repo, ok := byID[id]
+			if !ok {
+				repo = &rpmRepo{id: id}
+				byID[id] = repo
+				repos = append(repos, repo)
+			}
+			if repo.repomd != "" {
+				continue
+			}
+			repo.repomd = path
+			if repo.baseURL == "" {
+				repo.baseURL = r.readCachedMirror(dir)
+			}
+		}
+	}
+
+	return repos
+}
+
+// readRepoConfig returns the base URLs of the enabled repositories in a `.repo` file keyed by their IDs. Repositories
+// that are only reachable through a mirror list or a metalink have an empty base URL.
+func (r *rpm) readRepoConfig(path string) map[string]string {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	ret := make(map[string]string)
+	var id, key string
+	enabled := make(map[string]bool)
+	s := bufio.NewScanner(file)
+	for s.Scan() {
+		line := s.Text()
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";"):
+			continue
+		case strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]"):
+			id = strings.TrimSpace(trimmed[1 : len(trimmed)-1])
+			ret[id] = ""
+			enabled[id] = true
+			key = ""
+			continue
+		case id == "":
+			continue
+		}
+
+		// A line starting with a space continues the value of the previous key, as in a list of base URLs.
+		value := trimmed
+		if line[0] != ' ' && line[0] != '\t' {
+			k, v, ok := strings.Cut(line, "=")
+			if !ok {
+				continue
+			}
+			key, value = strings.ToLower(strings.TrimSpace(k)), strings.TrimSpace(v)
+		}
+
+		switch key {
+		case "baseurl":
+			if urls := strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' }); ret[id] == "" && len(urls) > 0 {
+				ret[id] = urls[0]
+			}
+		case "enabled":
+			enabled[id] = value != "0" && !strings.EqualFold(value, "false") && !strings.EqualFold(value, "no")
+		}
+	}
+
+	for id := range ret {
+		if !enabled[id] {
+			delete(ret, id)
+		}
+	}
+
+	return ret
+}
+
+// readCachedMirror returns the first mirror in the mirror list or the metalink that dnf cached for a repository, or an
+// empty string if there is neither.
+func (r *rpm) readCachedMirror(dir string) string {
+	if mirrorlist, err := os.ReadFile(filepath.Join(dir, "mirrorlist")); err == nil {
+		for _, line := range strings.Split(string(mirrorlist), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				return line
+			}
+		}
+	}
+
+	metalink, err := os.ReadFile(filepath.Join(dir, "metalink.xml"))
+	if err != nil {
+		return ""
+	}
+	var m struct {
+		URLs []struct {
+			Protocol string `xml:"protocol,attr"`
+			Value    string `xml:",chardata"`
+		} `xml:"files>file>resources>url"`
+	}
+	if err := xml.Unmarshal(metalink, &m); err != nil {
+		return ""
+	}
+	for _, u := range m.URLs {
+		if u.Protocol == "https" || u.Protocol == "http" {
+			return strings.TrimSuffix(strings.TrimSpace(u.Value), "repodata/repomd.xml")
+		}
+	}
+
+	return ""
+}
+
+// readRepoPrimary calls fn with each package in the primary metadata referred to by repomd.xml. dnf and zypper cache
+// the metadata in XML, and yum caches it in SQLite.
+func (r *rpm) readRepoPrimary(repomd string, fn func(*rpmRepoPackage)) error {
+	data, err := os.ReadFile(repomd)
+	if err != nil {
+		return err
+	}
+	var m struct {
+		Data []struct {
+			Type     string `xml:"type,attr"`
+			Location struct {
+				Href string `xml:"href,attr"`
+			} `xml:"location"`
+		} `xml:"data"`
+	}
+	if err := xml.Unmarshal(data, &m); err != nil {
+		return err
+	}
+
+	locations := make(map[string]string)
+	for _, d := range m.Data {
+		locations[d.Type] = d.Location.Href
+	}
+
+	// The locations are relative to the repository, whose repodata directory holds repomd.xml. yum puts the files in
+	// the same directory as repomd.xml instead.
+	find := func(href string) string {
+		if href == "" {
+			return ""
+		}
+		dir := filepath.Dir(repomd)
+		for _, path := range []string{filepath.Join(filepath.Dir(dir), href), filepath.Join(dir, filepath.Base(href))} {
+			if _, err := os.Stat(path); err == nil {
+				return path
+			}
+		}
+		return ""
+	}
+
+	if path := find(locations["primary"]); path != "" {
+		return r.readRepoPrimaryXML(path, fn)
+	}
+	if path := find(locations["primary_db"]); path != "" {
+		return r.readRepoPrimarySqlite(path, fn)
+	}
+
+	return fmt.Errorf("primary metadata not found")
+}
+
+// decompressRepoMetadata returns a reader of the uncompressed content of a metadata file, which createrepo_c compresses
+// with gzip, bzip2, xz or zstd depending on its options. An uncompressed file has the extension plain.
+func decompressRepoMetadata(path, plain string, r io.Reader) (io.ReadCloser, error) {
+	switch filepath.Ext(path) {
+	case plain:
+		return io.NopCloser(r), nil
+	case ".gz":
+		return gzip.NewReader(r)
+	case ".bz2":
+		return io.NopCloser(bzip2.NewReader(r)), nil
+	case ".xz":
+		x, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(x), nil
+	case ".zst":
+		z, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return z.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unsupported compression of %s", filepath.Base(path))
+	}
+}
+
+// readRepoPrimaryXML reads primary.xml, which is stored as is or compressed.
+func (r *rpm) readRepoPrimaryXML(path string, fn func(*rpmRepoPackage)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader, err := decompressRepoMetadata(path, ".xml", bufio.NewReader(file))
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	d := xml.NewDecoder(reader)
+	for {
+		token, err := d.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if start, ok := token.(xml.StartElement); ok && start.Name.Local == "package" {
+			var p rpmRepoPackage
+			if err := d.DecodeElement(&p, &start); err != nil {
+				return err
+			}
+			fn(&p)
+		}
+	}
+}
+
+// readRepoPrimarySqlite reads primary.sqlite, which is stored as is or compressed. A compressed database is read into
+// memory.
+func (r *rpm) readRepoPrimarySqlite(path string, fn func(*rpmRepoPackage)) error {
+	var db *sqliteDB
+	if filepath.Ext(path) == ".sqlite" {
+		var err error
+		if db, err = openSqlite(path); err != nil {
+			return err
+		}
+		defer db.close()
+	} else {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		reader, err := decompressRepoMetadata(path, ".sqlite", bufio.NewReader(file))
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+		if db, err = newSqlite(bytes.NewReader(data), int64(len(data))); err != nil {
+			return err
+		}
+	}
+
+	return db.readTable("packages", func(row sqliteRow) error {
+		var p rpmRepoPackage
+		p.Name = row.string("name")
+		p.Arch = row.string("arch")
+		p.Version.Epoch = row.string("epoch")
+		p.Version.Ver = row.string("version")
+		p.Version.Rel = row.string("release")
+		p.Checksum.Type = row.string("checksum_type")
+		p.Checksum.Value = row.string("pkgId")
+		p.Location.Href = row.string("location_href")
+		p.Location.Base = row.string("location_base")
+		fn(&p)
+		return nil
+	})
+}
+
+// rpmBaseArch returns the most common architecture of the installed packages other than noarch and its base
+// architecture, which dnf and yum substitute for $arch and $basearch. Packages of the root directory may be built for
+// another architecture than spirat itself, so the packages are what tells the architecture of the system. Empty
+// strings are returned if all packages are noarch.
+func rpmBaseArch(entries []*rpmEntry) (string, string) {
+	counts := make(map[string]int)
+	for _, e := range entries {
+		if e.arch != "" && e.arch != "noarch" {
+			counts[e.arch]++
+		}
+	}
+
+	var arch string
+	for a, n := range counts {
+		if n > counts[arch] || n == counts[arch] && a < arch {
+			arch = a
+		}
+	}
+
+	switch arch {
+	case "i486", "i586", "i686", "athlon", "geode", "pentium3", "pentium4":
+		return arch, "i386"
+	case "amd64", "ia32e":
+		return arch, "x86_64"
+	case "armv7hl", "armv7hnl", "armv8hl", "armv8hnl", "armv6hl":
+		return arch, "armhfp"
+	case "armv5tel", "armv5tejl", "armv6l", "armv7l", "armv8l":
+		return arch, "arm"
+	case "ppc64p7":
+		return arch, "ppc64"
+	default:
+		return arch, arch
+	}
+}
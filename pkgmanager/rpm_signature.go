@@ -0,0 +1,177 @@
+package pkgmanager
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+)
+
+const (
+	openPGPTagSignature = 2
+
+	// Types of signature subpackets.
+	openPGPSubpacketIssuer            = 16
+	openPGPSubpacketIssuerFingerprint = 33
+)
+
+var openPGPPublicKeyAlgorithms = map[byte]string{
+	1:  "RSA",
+	2:  "RSA",
+	3:  "RSA",
+	17: "DSA",
+	19: "ECDSA",
+	22: "EdDSA",
+}
+
+var openPGPHashAlgorithms = map[byte]string{
+	1:  "MD5",
+	2:  "SHA1",
+	8:  "SHA256",
+	9:  "SHA384",
+	10: "SHA512",
+	11: "SHA224",
+}
+
+// parseOpenPGPSignature extracts the key ID and the algorithms from an OpenPGP signature packet as stored in the
+// RSAHEADER, DSAHEADER, SIGPGP and SIGGPG tags of rpm. Version 3 signatures have the key ID at a fixed position, and
+// version 4 signatures have it in an issuer subpacket.
+func parseOpenPGPSignature(packet []byte) (*Signature, error) {
+	tag, body, err := openPGPPacketBody(packet)
+	if err != nil {
+		return nil, err
+	}
+	if tag != openPGPTagSignature || len(body) == 0 {
+		return nil, fmt.Errorf("not an OpenPGP signature")
+	}
+
+	var keyID []byte
+	var pubKeyAlgo, hashAlgo byte
+	switch body[0] {
+	case 3:
+		if len(body) < 17 {
+			return nil, fmt.Errorf("OpenPGP signature too short")
+		}
+		keyID = body[7:15]
+		pubKeyAlgo, hashAlgo = body[15], body[16]
+	case 4:
+		if len(body) < 6 {
+			return nil, fmt.Errorf("OpenPGP signature too short")
+		}
+		pubKeyAlgo, hashAlgo = body[2], body[3]
+
+		// The hashed subpackets are followed by the unhashed subpackets, where the issuer usually is.
+		rest := body[4:]
+		for i := 0; i < 2 && keyID == nil; i++ {
+			if len(rest) < 2 {
+				break
+			}
+			n := int(binary.BigEndian.Uint16(rest))
+			if 2+n > len(rest) {
+				return nil, fmt.Errorf("OpenPGP signature too short")
+			}
+			keyID = openPGPIssuer(rest[2 : 2+n])
+			rest = rest[2+n:]
+		}
+	default:
+		return nil, fmt.Errorf("unsupported OpenPGP signature version %d", body[0])
+	}
+
+	if keyID == nil {
+		return nil, fmt.Errorf("OpenPGP signature without an issuer")
+	}
+
+	s := &Signature{KeyID: hex.EncodeToString(keyID)}
+	if pubKey, ok := openPGPPublicKeyAlgorithms[pubKeyAlgo]; ok {
+		s.Algorithm = pubKey
+		if hash, ok := openPGPHashAlgorithms[hashAlgo]; ok {
+			s.Algorithm += "/" + hash
+		}
+	}
+
+	return s, nil
+}
+
+// openPGPPacketBody returns the tag and the body of a packet in either the old or the new format.
+func openPGPPacketBody(packet []byte) (byte, []byte, error) {
+	if len(packet) < 2 || packet[0]&0x80 == 0 {
+		return 0, nil, fmt.Errorf("invalid OpenPGP packet")
+	}
+
+	var tag byte
+	var length, offset int
+	if packet[0]&0x40 != 0 {
+		tag = packet[0] & 0x3f
+		switch l := packet[1]; {
+		case l < 192:
+			length, offset = int(l), 2
+		case l < 224 && len(packet) >= 3:
+			length, offset = (int(l)-192)<<8+int(packet[2])+192, 3
+		case l == 255 && len(packet) >= 6:
+			length, offset = int(binary.BigEndian.Uint32(packet[2:])), 6
+		default:
+			return 0, nil, fmt.Errorf("unsupported OpenPGP packet length")
+		}
+	} else {
+		tag = packet[0] >> 2 & 0x0f
+		switch packet[0] & 0x03 {
+		case 0:
+			length, offset = int(packet[1]), 2
+		case 1:
+			if len(packet) < 3 {
+				return 0, nil, fmt.Errorf("invalid OpenPGP packet")
+			}
+			length, offset = int(binary.BigEndian.Uint16(packet[1:])), 3
+		case 2:
+			if len(packet) < 5 {
+				return 0, nil, fmt.Errorf("invalid OpenPGP packet")
+			}
+			length, offset = int(binary.BigEndian.Uint32(packet[1:])), 5
+		default:
+			// The packet extends to the end of the data.
+			length, offset = len(packet)-1, 1
+		}
+	}
+
+	if offset+length > len(packet) {
+		return 0, nil, fmt.Errorf("OpenPGP packet truncated")
+	}
+
+	return tag, packet[offset : offset+length], nil
+}
+
+// openPGPIssuer returns the key ID in the issuer or issuer fingerprint subpacket, or nil if there is neither.
+func openPGPIssuer(subpackets []byte) []byte {
+	for len(subpackets) > 0 {
+		var length, offset int
+		switch l := subpackets[0]; {
+		case l < 192:
+			length, offset = int(l), 1
+		case l < 255 && len(subpackets) >= 2:
+			length, offset = (int(l)-192)<<8+int(subpackets[1])+192, 2
+		case l == 255 && len(subpackets) >= 5:
+			length, offset = int(binary.BigEndian.Uint32(subpackets[1:])), 5
+		default:
+			return nil
+		}
+		if length == 0 || offset+length > len(subpackets) {
+			return nil
+		}
+
+		// The length includes the type, whose highest bit marks critical subpackets.
+		data := subpackets[offset+1 : offset+length]
+		switch subpackets[offset] & 0x7f {
+		case openPGPSubpacketIssuer:
+			if len(data) == 8 {
+				return data
+			}
+		case openPGPSubpacketIssuerFingerprint:
+			// The fingerprint follows the key version, and a v4 key ID is its last eight bytes.
+			if len(data) >= 9 && data[0] == 4 {
+				return data[len(data)-8:]
+			}
+		}
+		subpackets = subpackets[offset+length:]
+	}
+
+	return nil
+}
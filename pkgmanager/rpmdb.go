@@ -0,0 +1,35 @@
+package pkgmanager
+
+import (
+	"os"
+)
+
+// rpmDatabase is a location of the rpm database and the function that reads the header blobs stored there.
+type rpmDatabase struct {
+	path string
+	read func(path string, fn func([]byte) error) error
+}
+
+// rpmDatabases are the known locations of the rpm database. Fedora and openSUSE moved the database from /var/lib/rpm to
+// /usr/lib/sysimage/rpm, leaving a symbolic link in the old place. Newer backends come first as the files of older
+// ones may be left behind by a conversion.
+var rpmDatabases = []rpmDatabase{
+	{"/usr/lib/sysimage/rpm/rpmdb.sqlite", readRpmSqlite},
+	{"/var/lib/rpm/rpmdb.sqlite", readRpmSqlite},
+	{"/usr/lib/sysimage/rpm/Packages.db", readRpmNdb},
+	{"/var/lib/rpm/Packages.db", readRpmNdb},
+	{"/usr/lib/sysimage/rpm/Packages", readRpmBdb},
+	{"/var/lib/rpm/Packages", readRpmBdb},
+}
+
+// findRpmDatabase returns the first rpm database that exists under Options.Root, or nil if there is none.
+func findRpmDatabase() *rpmDatabase {
+	for _, db := range rpmDatabases {
+		path := rootPath(db.path)
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			return &rpmDatabase{path: path, read: db.read}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+package pkgmanager
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+const (
+	bdbHashMagic = 0x061561
+
+	// Types of pages.
+	bdbPageHashUnsorted = 2
+	bdbPageOverflow     = 7
+	bdbPageHash         = 13
+
+	// Types of items on hash pages.
+	bdbItemKeyData = 1
+	bdbItemOffPage = 3
+
+	bdbPageHeaderSize = 26
+)
+
+// readRpmBdb calls fn with the header blob of each package in the BerkeleyDB hash database `Packages`, which is used by
+// rpm 4.15 and earlier. Only the hash pages and the overflow pages they point to are read, so neither the btree indexes
+// nor the environment files next to `Packages` are needed.
+func readRpmBdb(path string, fn func([]byte) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// The metadata page starts with the LSN, the page number, the magic number, the version and the page size. The
+	// database is written in the byte order of the host that created it.
+	meta := make([]byte, 72)
+	if _, err := file.ReadAt(meta, 0); err != nil {
+		return err
+	}
+
+	var order binary.ByteOrder
+	switch {
+	case binary.LittleEndian.Uint32(meta[12:]) == bdbHashMagic:
+		order = binary.LittleEndian
+	case binary.BigEndian.Uint32(meta[12:]) == bdbHashMagic:
+		order = binary.BigEndian
+	default:
+		return fmt.Errorf("bdb: not a hash database")
+	}
+	if meta[24] != 0 {
+		return fmt.Errorf("bdb: encrypted databases are not supported")
+	}
+
+	pageSize := int64(order.Uint32(meta[20:]))
+	lastPage := order.Uint32(meta[32:])
+	if pageSize < 512 || pageSize > 65536 || pageSize&(pageSize-1) != 0 {
+		return fmt.Errorf("bdb: invalid page size %d", pageSize)
+	}
+	// maxLength bounds the length of an item stored on overflow pages, which cannot be larger than the database.
+	maxLength := uint64(lastPage) * uint64(pageSize)
+
+	readPage := func(n uint32) ([]byte, error) {
+		page := make([]byte, pageSize)
+		_, err := file.ReadAt(page, int64(n)*pageSize)
+		return page, err
+	}
+
+	for n := uint32(1); n <= lastPage; n++ {
+		page, err := readPage(n)
+		if err != nil {
+			return err
+		}
+		if typ := page[25]; typ != bdbPageHash && typ != bdbPageHashUnsorted {
+			continue
+		}
+
+		// The page header is followed by the offsets of the items, which alternate between keys and values. The keys
+		// are the header numbers stored in the host byte order.
+		entries := int(order.Uint16(page[20:]))
+		if bdbPageHeaderSize+2*entries > len(page) {
+			return fmt.Errorf("bdb: corrupt page %d", n)
+		}
+		for i := 0; i+1 < entries; i += 2 {
+			keyOffset := int(order.Uint16(page[bdbPageHeaderSize+2*i:]))
+			offset := int(order.Uint16(page[bdbPageHeaderSize+2*i+2:]))
+			if keyOffset+5 > len(page) || offset >= keyOffset {
+				return fmt.Errorf("bdb: corrupt page %d", n)
+			}
+
+			// The record with the key 0 holds the next header number rather than a header.
+			if page[keyOffset] == bdbItemKeyData && order.Uint32(page[keyOffset+1:]) == 0 {
+				continue
+			}
+
+			switch page[offset] {
+			case bdbItemOffPage:
+				// Most headers are too large for a page, so they are stored on a chain of overflow pages.
+				if offset+12 > len(page) {
+					return fmt.Errorf("bdb: corrupt page %d", n)
+				}
+				length := order.Uint32(page[offset+8:])
+				if uint64(length) > maxLength {
+					return fmt.Errorf("bdb: corrupt page %d: item of %d bytes out of range", n, length)
+				}
+				blob, err := readBdbOverflow(readPage, order, order.Uint32(page[offset+4:]), length)
+				if err != nil {
+					return fmt.Errorf("bdb: corrupt page %d: %w", n, err)
+				}
+				if err := fn(blob); err != nil {
+					return err
+				}
+			case bdbItemKeyData:
+				// Items are stored from the end of the page downwards, so a value ends where its key starts.
+				if err := fn(page[offset+1 : keyOffset]); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func readBdbOverflow(readPage func(uint32) ([]byte, error), order binary.ByteOrder, n, length uint32) ([]byte, error) {
+	blob := make([]byte, 0, length)
+	for n != 0 && uint32(len(blob)) < length {
+		page, err := readPage(n)
+		if err != nil {
+			return nil, err
+		}
+		if page[25] != bdbPageOverflow {
+			return nil, fmt.Errorf("page %d is not an overflow page", n)
+		}
+
+		// The offset of the free area is the number of bytes used on an overflow page.
+		used := int(order.Uint16(page[22:]))
+		if used == 0 || bdbPageHeaderSize+used > len(page) {
+			return nil, fmt.Errorf("overflow page %d out of range", n)
+		}
+		blob = append(blob, page[bdbPageHeaderSize:bdbPageHeaderSize+used]...)
+		n = order.Uint32(page[16:])
+	}
+
+	if uint32(len(blob)) != length {
+		return nil, fmt.Errorf("overflow chain of %d bytes instead of %d", len(blob), length)
+	}
+
+	return blob, nil
+}
@@ -0,0 +1,97 @@
+package pkgmanager
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+const (
+	ndbHeaderMagic = 'R' | 'p'<<8 | 'm'<<16 | 'P'<<24
+	ndbSlotMagic   = 'S' | 'l'<<8 | 'o'<<16 | 't'<<24
+	ndbBlobMagic   = 'B' | 'l'<<8 | 'b'<<16 | 'S'<<24
+
+	ndbVersion  = 0
+	ndbPageSize = 4096
+	// ndbSlotSize is the size of the header and of each slot, which consist of four 32-bit numbers.
+	ndbSlotSize = 16
+	// ndbBlockSize is the unit of the offsets of blobs.
+	ndbBlockSize = 16
+	// ndbMaxSlotPages is the limit of rpm on the number of slot pages.
+	ndbMaxSlotPages = 2048
+)
+
+// readRpmNdb calls fn with the header blob of each package in the ndb database `Packages.db`, which is used by SUSE.
+// The file starts with slot pages, whose first slot is the file header. Each of the other slots locates the blob of a
+// package. All numbers are little-endian.
+func readRpmNdb(path string, fn func([]byte) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// The header takes two slots and starts with the magic number, the version, the generation and the number of slot
+	// pages.
+	header := make([]byte, ndbSlotSize)
+	if _, err := file.ReadAt(header, 0); err != nil {
+		return err
+	}
+	if binary.LittleEndian.Uint32(header) != ndbHeaderMagic {
+		return fmt.Errorf("ndb: not a package database")
+	}
+	if v := binary.LittleEndian.Uint32(header[4:]); v != ndbVersion {
+		return fmt.Errorf("ndb: unsupported version %d", v)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	slotPages := binary.LittleEndian.Uint32(header[12:])
+	if slotPages == 0 || slotPages > ndbMaxSlotPages || int64(slotPages)*ndbPageSize > info.Size() {
+		return fmt.Errorf("ndb: invalid number of slot pages %d", slotPages)
+	}
+	slots := make([]byte, int64(slotPages)*ndbPageSize)
+	if _, err := file.ReadAt(slots, 0); err != nil {
+		return err
+	}
+
+	for offset := 2 * ndbSlotSize; offset+ndbSlotSize <= len(slots); offset += ndbSlotSize {
+		slot := slots[offset:]
+		if binary.LittleEndian.Uint32(slot) != ndbSlotMagic {
+			return fmt.Errorf("ndb: corrupt slot at %d", offset)
+		}
+
+		// An empty slot has no package index.
+		pkgIndex := binary.LittleEndian.Uint32(slot[4:])
+		if pkgIndex == 0 {
+			continue
+		}
+		blkOffset := int64(binary.LittleEndian.Uint32(slot[8:])) * ndbBlockSize
+
+		// The blob header consists of the magic number, the package index, the generation and the length.
+		blobHeader := make([]byte, 16)
+		if _, err := file.ReadAt(blobHeader, blkOffset); err != nil {
+			return fmt.Errorf("ndb: failed to read package %d: %w", pkgIndex, err)
+		}
+		if binary.LittleEndian.Uint32(blobHeader) != ndbBlobMagic || binary.LittleEndian.Uint32(blobHeader[4:]) != pkgIndex {
+			return fmt.Errorf("ndb: corrupt blob of package %d", pkgIndex)
+		}
+
+		length := int64(binary.LittleEndian.Uint32(blobHeader[12:]))
+		if blkOffset+int64(len(blobHeader))+length > info.Size() {
+			return fmt.Errorf("ndb: corrupt blob of package %d: %d bytes out of range", pkgIndex, length)
+		}
+		blob := make([]byte, length)
+		if _, err := file.ReadAt(blob, blkOffset+int64(len(blobHeader))); err != nil {
+			return fmt.Errorf("ndb: failed to read package %d: %w", pkgIndex, err)
+		}
+		if err := fn(blob); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
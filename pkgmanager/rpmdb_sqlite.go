@@ -0,0 +1,548 @@
+package pkgmanager
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	sqliteHeaderMagic = "SQLite format 3\x00"
+	sqliteWALMagic    = 0x377f0682
+
+	sqliteTableInterior = 0x05
+	sqliteTableLeaf     = 0x0d
+
+	// sqliteMaxDepth is the maximum depth of a b-tree, as in SQLite itself, so that a page that points back to one of
+	// its ancestors cannot recurse forever.
+	sqliteMaxDepth = 20
+)
+
+// sqliteDB is a read-only reader of the SQLite file format that is just enough to read the tables of rpmdb.sqlite and
+// of the metadata that dnf and yum keep next to it. Pages committed to the write-ahead log but not checkpointed yet
+// take precedence over the database file.
+type sqliteDB struct {
+	file     io.ReaderAt
+	pageSize int
+	usable   int
+	// pages is the number of pages in the database file and the write-ahead log, which bounds the size of a payload.
+	pages int
+	// wal maps page numbers to the offsets of their latest committed frames in walFile.
+	wal     map[uint32]int64
+	walFile io.ReaderAt
+}
+
+// sqliteRow maps the column names of a table to the values of a row.
+type sqliteRow map[string]interface{}
+
+func (r sqliteRow) string(column string) string {
+	s, _ := r[column].(string)
+	return s
+}
+
+func (r sqliteRow) int(column string) int64 {
+	i, _ := r[column].(int64)
+	return i
+}
+
+// readRpmSqlite calls fn with the header blob of each package in rpmdb.sqlite, which is used by rpm 4.16 and later.
+func readRpmSqlite(path string, fn func([]byte) error) error {
+	db, err := openSqlite(path)
+	if err != nil {
+		return err
+	}
+	defer db.close()
+
+	root, _, err := db.findTable("Packages")
+	if err != nil {
+		return err
+	}
+
+	// The table has the columns hnum, which is an alias of the rowid, and blob.
+	return db.walkTable(root, func(_ int64, values []interface{}) error {
+		if len(values) < 2 {
+			return fmt.Errorf("sqlite: invalid Packages row")
+		}
+		blob, ok := values[1].([]byte)
+		if !ok {
+			return fmt.Errorf("sqlite: invalid Packages row")
+		}
+		return fn(blob)
+	})
+}
+
+// openSqlite opens the database at path along with its write-ahead log, if any.
+func openSqlite(path string) (*sqliteDB, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	db, err := newSqlite(file, info.Size())
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if wal, err := os.Open(path + "-wal"); err == nil {
+		db.walFile = wal
+		if err := db.readWAL(wal); err != nil {
+			db.close()
+			return nil, err
+		}
+	}
+
+	return db, nil
+}
+
+// newSqlite reads the header of a database of size bytes without a write-ahead log.
+func newSqlite(file io.ReaderAt, size int64) (*sqliteDB, error) {
+	db := &sqliteDB{file: file}
+	if err := db.readHeader(); err != nil {
+		return nil, err
+	}
+	db.pages = int(size / int64(db.pageSize))
+
+	return db, nil
+}
+
+func (db *sqliteDB) close() {
+	for _, f := range []io.ReaderAt{db.file, db.walFile} {
+		if c, ok := f.(io.Closer); ok {
+			c.Close()
+		}
+	}
+}
+
+// readTable calls fn with each row of a table. The columns are looked up in the statement
+// that created the table, so columns added by later versions of a schema are simply missing from older databases.
+func (db *sqliteDB) readTable(table string, fn func(sqliteRow) error) error {
+	root, sql, err := db.findTable(table)
+	if err != nil {
+		return err
+	}
+
+	columns, rowidColumn := sqliteColumns(sql)
+	return db.walkTable(root, func(rowid int64, values []interface{}) error {
+		row := make(sqliteRow, len(columns))
+		for i, column := range columns {
+			if i < len(values) {
+				row[column] = values[i]
+			}
+		}
+		// A column declared as INTEGER PRIMARY KEY is an alias of the rowid and stored as NULL in the record.
+		if rowidColumn != "" {
+			row[rowidColumn] = rowid
+		}
+		return fn(row)
+	})
+}
+
+// sqliteColumns returns the names of the columns in a CREATE TABLE statement and the name of the column that is an
+// alias of the rowid, if any. Table constraints such as `CONSTRAINT ... UNIQUE (...)` are skipped.
+func sqliteColumns(sql string) ([]string, string) {
+	start, end := strings.Index(sql, "("), strings.LastIndex(sql, ")")
+	if start < 0 || end < start {
+		return nil, ""
+	}
+
+	var definitions []string
+	depth, last := 0, start+1
+	for i := start + 1; i < end; i++ {
+		switch sql[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				definitions = append(definitions, sql[last:i])
+				last = i + 1
+			}
+		}
+	}
+	definitions = append(definitions, sql[last:end])
+
+	var columns []string
+	var rowidColumn string
+	for _, d := range definitions {
+		fields := strings.Fields(d)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "CONSTRAINT", "PRIMARY", "UNIQUE", "CHECK", "FOREIGN":
+			continue
+		}
+
+		name := strings.Trim(fields[0], "\"`[]")
+		columns = append(columns, name)
+		if len(fields) >= 4 && strings.EqualFold(fields[1], "INTEGER") && strings.EqualFold(fields[2], "PRIMARY") &&
+			strings.EqualFold(fields[3], "KEY") {
+			rowidColumn = name
+		}
+	}
+
+	return columns, rowidColumn
+}
+
+func (db *sqliteDB) readHeader() error {
+	header := make([]byte, 100)
+	if _, err := db.file.ReadAt(header, 0); err != nil {
+		return err
+	}
+	if string(header[:16]) != sqliteHeaderMagic {
+		return fmt.Errorf("sqlite: not a database")
+	}
+
+	db.pageSize = int(binary.BigEndian.Uint16(header[16:]))
+	if db.pageSize == 1 {
+		db.pageSize = 65536
+	}
+	if db.pageSize < 512 || db.pageSize&(db.pageSize-1) != 0 {
+		return fmt.Errorf("sqlite: invalid page size %d", db.pageSize)
+	}
+	db.usable = db.pageSize - int(header[20])
+	if db.usable < 480 {
+		return fmt.Errorf("sqlite: invalid reserved space %d", header[20])
+	}
+
+	return nil
+}
+
+// readWAL indexes the frames of the write-ahead log up to the last commit whose checksums are valid.
+func (db *sqliteDB) readWAL(wal io.ReaderAt) error {
+	header := make([]byte, 32)
+	if _, err := wal.ReadAt(header, 0); err != nil {
+		// An empty log has nothing to apply.
+		return nil
+	}
+
+	magic := binary.BigEndian.Uint32(header)
+	if magic&^1 != sqliteWALMagic {
+		return fmt.Errorf("sqlite: invalid write-ahead log")
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if magic&1 == 1 {
+		order = binary.BigEndian
+	}
+
+	s0, s1 := sqliteChecksum(order, header[:24], 0, 0)
+	if s0 != binary.BigEndian.Uint32(header[24:]) || s1 != binary.BigEndian.Uint32(header[28:]) {
+		return nil
+	}
+
+	pageSize := int64(binary.BigEndian.Uint32(header[8:]))
+	if pageSize != int64(db.pageSize) {
+		return fmt.Errorf("sqlite: invalid write-ahead log")
+	}
+	salt := header[16:24]
+
+	committed := make(map[uint32]int64)
+	pending := make(map[uint32]int64)
+	frame := make([]byte, 24+pageSize)
+	for offset := int64(32); ; offset += int64(len(frame)) {
+		if _, err := wal.ReadAt(frame, offset); err != nil {
+			break
+		}
+		if !bytes.Equal(frame[8:16], salt) {
+			break
+		}
+
+		s0, s1 = sqliteChecksum(order, frame[:8], s0, s1)
+		s0, s1 = sqliteChecksum(order, frame[24:], s0, s1)
+		if s0 != binary.BigEndian.Uint32(frame[16:]) || s1 != binary.BigEndian.Uint32(frame[20:]) {
+			break
+		}
+
+		pending[binary.BigEndian.Uint32(frame)] = offset + 24
+		if binary.BigEndian.Uint32(frame[4:]) != 0 {
+			// A commit frame makes the frames of its transaction visible.
+			for page, o := range pending {
+				committed[page] = o
+			}
+			pending = make(map[uint32]int64)
+		}
+	}
+
+	db.wal = committed
+	db.walFile = wal
+	db.pages += len(committed)
+	return nil
+}
+
+func sqliteChecksum(order binary.ByteOrder, data []byte, s0, s1 uint32) (uint32, uint32) {
+	for i := 0; i+8 <= len(data); i += 8 {
+		s0 += order.Uint32(data[i:]) + s1
+		s1 += order.Uint32(data[i+4:]) + s0
+	}
+	return s0, s1
+}
+
+func (db *sqliteDB) page(n uint32) ([]byte, error) {
+	if n == 0 {
+		return nil, fmt.Errorf("sqlite: invalid page number")
+	}
+
+	buf := make([]byte, db.pageSize)
+	if offset, ok := db.wal[n]; ok {
+		_, err := db.walFile.ReadAt(buf, offset)
+		return buf, err
+	}
+
+	_, err := db.file.ReadAt(buf, int64(n-1)*int64(db.pageSize))
+	return buf, err
+}
+
+// findTable returns the root page of a table and the statement that created it from the schema table, which is rooted
+// at the first page.
+func (db *sqliteDB) findTable(name string) (uint32, string, error) {
+	var root uint32
+	var sql string
+	err := db.walkTable(1, func(_ int64, values []interface{}) error {
+		// The schema table has the columns type, name, tbl_name, rootpage and sql.
+		if len(values) < 5 || root != 0 {
+			return nil
+		}
+		if typ, _ := values[0].(string); typ != "table" {
+			return nil
+		}
+		if n, _ := values[1].(string); n != name {
+			return nil
+		}
+		if page, ok := values[3].(int64); ok {
+			root = uint32(page)
+			sql, _ = values[4].(string)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, "", err
+	}
+	if root == 0 {
+		return 0, "", fmt.Errorf("sqlite: table %s not found", name)
+	}
+
+	return root, sql, nil
+}
+
+// walkTable calls fn with the rowid and the values of each row of the table b-tree rooted at root in the order of
+// rowids.
+func (db *sqliteDB) walkTable(root uint32, fn func(int64, []interface{}) error) error {
+	return db.walkPage(root, 0, fn)
+}
+
+func (db *sqliteDB) walkPage(n uint32, depth int, fn func(int64, []interface{}) error) error {
+	if depth > sqliteMaxDepth {
+		return fmt.Errorf("sqlite: corrupt page %d: b-tree too deep", n)
+	}
+
+	page, err := db.page(n)
+	if err != nil {
+		return err
+	}
+
+	// The first page starts with the database header. The page header takes 8 bytes on leaf pages and 12 bytes on
+	// interior pages, which also hold the right-most child, and is followed by the offsets of the cells.
+	h := 0
+	if n == 1 {
+		h = 100
+	}
+	headerSize := 8
+	if page[h] == sqliteTableInterior {
+		headerSize = 12
+	}
+	if h+headerSize > len(page) {
+		return fmt.Errorf("sqlite: corrupt page %d", n)
+	}
+	cells := int(binary.BigEndian.Uint16(page[h+3:]))
+	if h+headerSize+2*cells > len(page) {
+		return fmt.Errorf("sqlite: corrupt page %d: %d cells out of range", n, cells)
+	}
+	pointers := page[h+headerSize:]
+
+	switch page[h] {
+	case sqliteTableInterior:
+		for i := 0; i < cells; i++ {
+			cell := int(binary.BigEndian.Uint16(pointers[2*i:]))
+			if cell+4 > len(page) {
+				return fmt.Errorf("sqlite: corrupt page %d: cell out of range", n)
+			}
+			if err := db.walkPage(binary.BigEndian.Uint32(page[cell:]), depth+1, fn); err != nil {
+				return err
+			}
+		}
+		return db.walkPage(binary.BigEndian.Uint32(page[h+8:]), depth+1, fn)
+	case sqliteTableLeaf:
+		for i := 0; i < cells; i++ {
+			cell := int(binary.BigEndian.Uint16(pointers[2*i:]))
+			rowid, payload, err := db.payload(page, cell)
+			if err != nil {
+				return fmt.Errorf("sqlite: corrupt page %d: %w", n, err)
+			}
+			values, err := sqliteRecord(payload)
+			if err != nil {
+				return fmt.Errorf("sqlite: corrupt page %d: %w", n, err)
+			}
+			if err := fn(rowid, values); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("sqlite: page %d is not a table page", n)
+	}
+}
+
+// payload returns the rowid and the payload of a cell of a table leaf page, following the overflow pages.
+func (db *sqliteDB) payload(page []byte, cell int) (int64, []byte, error) {
+	if cell >= len(page) {
+		return 0, nil, fmt.Errorf("cell out of range")
+	}
+
+	size, n := sqliteVarint(page[cell:])
+	if n == 0 || size > uint64(db.pages)*uint64(db.usable) {
+		return 0, nil, fmt.Errorf("invalid payload size")
+	}
+	cell += n
+	rowid, n := sqliteVarint(page[cell:])
+	if n == 0 {
+		return 0, nil, fmt.Errorf("invalid rowid")
+	}
+	cell += n
+
+	// See "B-tree Pages" in the documentation of the file format for how much of the payload is stored in the cell.
+	local := int(size)
+	x := db.usable - 35
+	if local > x {
+		m := (db.usable-12)*32/255 - 23
+		local = m + (int(size)-m)%(db.usable-4)
+		if local > x {
+			local = m
+		}
+	}
+	if cell+local > len(page) {
+		return 0, nil, fmt.Errorf("payload out of range")
+	}
+
+	payload := make([]byte, 0, size)
+	payload = append(payload, page[cell:cell+local]...)
+	if local == int(size) {
+		return int64(rowid), payload, nil
+	}
+
+	if cell+local+4 > len(page) {
+		return 0, nil, fmt.Errorf("payload out of range")
+	}
+	next := binary.BigEndian.Uint32(page[cell+local:])
+	for len(payload) < int(size) {
+		overflow, err := db.page(next)
+		if err != nil {
+			return 0, nil, fmt.Errorf("overflow page %d: %w", next, err)
+		}
+		next = binary.BigEndian.Uint32(overflow)
+		end := db.usable
+		if rest := int(size) - len(payload) + 4; rest < end {
+			end = rest
+		}
+		payload = append(payload, overflow[4:end]...)
+	}
+
+	return int64(rowid), payload, nil
+}
+
+// sqliteRecord decodes a record into int64, string, []byte and nil values. Floats are left as their raw uint64 bits.
+func sqliteRecord(payload []byte) ([]interface{}, error) {
+	headerSize, n := sqliteVarint(payload)
+	if n == 0 || headerSize > uint64(len(payload)) {
+		return nil, fmt.Errorf("invalid record header")
+	}
+
+	var types []uint64
+	for i := n; i < int(headerSize); {
+		t, n := sqliteVarint(payload[i:int(headerSize)])
+		if n == 0 {
+			return nil, fmt.Errorf("invalid record header")
+		}
+		types = append(types, t)
+		i += n
+	}
+
+	values := make([]interface{}, 0, len(types))
+	body := payload[headerSize:]
+	for _, t := range types {
+		var size uint64
+		switch {
+		case t == 0, t == 8, t == 9:
+			size = 0
+		case t <= 4:
+			size = t
+		case t == 5:
+			size = 6
+		case t == 6, t == 7:
+			size = 8
+		case t >= 12:
+			size = (t - 12) / 2
+		default:
+			return nil, fmt.Errorf("invalid serial type %d", t)
+		}
+		if size > uint64(len(body)) {
+			return nil, fmt.Errorf("record out of range")
+		}
+
+		v := body[:size]
+		body = body[size:]
+		switch {
+		case t == 0:
+			values = append(values, nil)
+		case t == 8:
+			values = append(values, int64(0))
+		case t == 9:
+			values = append(values, int64(1))
+		case t <= 6:
+			// Big-endian two's complement integers of various sizes.
+			var i int64
+			if v[0]&0x80 != 0 {
+				i = -1
+			}
+			for _, b := range v {
+				i = i<<8 | int64(b)
+			}
+			values = append(values, i)
+		case t == 7:
+			values = append(values, binary.BigEndian.Uint64(v))
+		case t%2 == 0:
+			values = append(values, v)
+		default:
+			values = append(values, string(v))
+		}
+	}
+
+	return values, nil
+}
+
+// sqliteVarint decodes a big-endian variable-length integer of up to 9 bytes. It reads 0 bytes if b is too short.
+func sqliteVarint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < 9; i++ {
+		if i == len(b) {
+			return 0, 0
+		}
+		if i == 8 {
+			return v<<8 | uint64(b[i]), 9
+		}
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i]&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+	return v, 9
+}
@@ -0,0 +1,133 @@
+package pkgmanager
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+//go:generate go run ./testdata/rpmdb/generate.go testdata/rpmdb
+
+// The databases in testdata/rpmdb hold the same twelve headers, one of which spans several pages. They are written by
+// testdata/rpmdb/generate.go.
+var rpmdbReaders = []struct {
+	file string
+	read func(string, func([]byte) error) error
+}{
+	{"rpmdb.sqlite", readRpmSqlite},
+	{"Packages", readRpmBdb},
+	{"Packages.db", readRpmNdb},
+}
+
+// readRpmdbNames returns the NAME tags of the headers in a database.
+func readRpmdbNames(path string, read func(string, func([]byte) error) error) ([]string, error) {
+	var names []string
+	err := read(path, func(blob []byte) error {
+		h, err := parseRpmHeader(blob)
+		if err != nil {
+			return err
+		}
+		// Touch every accessor so that corrupt headers are exercised as well.
+		h.strings(rpmTagLicense)
+		h.ints(rpmTagEpoch)
+		h.paths()
+		names = append(names, h.string(rpmTagName))
+		return nil
+	})
+	return names, err
+}
+
+func TestReadRpmdb(t *testing.T) {
+	for _, r := range rpmdbReaders {
+		names, err := readRpmdbNames(filepath.Join("testdata", "rpmdb", r.file), r.read)
+		if err != nil {
+			t.Errorf("%s: %v", r.file, err)
+			continue
+		}
+		if len(names) != 12 || names[0] != "bash" || names[1] != "glibc" || names[11] != "pkg09" {
+			t.Errorf("%s: got %q", r.file, names)
+		}
+	}
+}
+
+func TestReadRpmdbCorrupt(t *testing.T) {
+	le := binary.LittleEndian
+	tests := []struct {
+		file    string
+		corrupt func([]byte) []byte
+	}{
+		// The cell count of the interior page of the Packages table and of one of its leaves.
+		{"rpmdb.sqlite", func(b []byte) []byte { binary.BigEndian.PutUint16(b[512+3:], 0xffff); return b }},
+		{"rpmdb.sqlite", func(b []byte) []byte { binary.BigEndian.PutUint16(b[5*512+3:], 0xffff); return b }},
+		// A child of the interior page that points back to the interior page.
+		{"rpmdb.sqlite", func(b []byte) []byte { binary.BigEndian.PutUint32(b[512+8:], 2); return b }},
+		{"rpmdb.sqlite", func(b []byte) []byte { binary.BigEndian.PutUint16(b[16:], 3); return b }},
+		{"rpmdb.sqlite", func(b []byte) []byte { return b[:3*512] }},
+		// The number of items on the hash page, the length of an item and the page size.
+		{"Packages", func(b []byte) []byte { le.PutUint16(b[512+20:], 0xffff); return b }},
+		{"Packages", func(b []byte) []byte { le.PutUint32(b[512+512-17+8:], 0xffffffff); return b }},
+		{"Packages", func(b []byte) []byte { le.PutUint32(b[20:], 0xffffffff); return b }},
+		{"Packages", func(b []byte) []byte { return b[:4*512] }},
+		// The number of slot pages and the length of a blob.
+		{"Packages.db", func(b []byte) []byte { le.PutUint32(b[12:], 0xffffffff); return b }},
+		{"Packages.db", func(b []byte) []byte { le.PutUint32(b[4096+12:], 0xffffffff); return b }},
+		{"Packages.db", func(b []byte) []byte { return b[:4096+100] }},
+	}
+
+	dir := t.TempDir()
+	for i, tt := range tests {
+		data, err := os.ReadFile(filepath.Join("testdata", "rpmdb", tt.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, tt.file)
+		if err := os.WriteFile(path, tt.corrupt(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, r := range rpmdbReaders {
+			if r.file != tt.file {
+				continue
+			}
+			if _, err := readRpmdbNames(path, r.read); err == nil {
+				t.Errorf("%d: %s: expected an error", i, tt.file)
+			}
+		}
+	}
+}
+
+// TestReadRpmdbMutated overwrites each byte of the databases and truncates them at every block. The readers may fail
+// but must not panic.
+func TestReadRpmdbMutated(t *testing.T) {
+	dir := t.TempDir()
+	for _, r := range rpmdbReaders {
+		data, err := os.ReadFile(filepath.Join("testdata", "rpmdb", r.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, r.file)
+
+		var variants [][]byte
+		for i := range data {
+			for _, b := range []byte{0x00, 0x7f, 0xff} {
+				if data[i] == b {
+					continue
+				}
+				v := append([]byte(nil), data...)
+				v[i] = b
+				variants = append(variants, v)
+			}
+		}
+		for n := 0; n < len(data); n += 16 {
+			variants = append(variants, data[:n])
+		}
+
+		for _, v := range variants {
+			if err := os.WriteFile(path, v, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			readRpmdbNames(path, r.read)
+		}
+	}
+}
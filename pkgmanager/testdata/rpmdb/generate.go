@@ -0,0 +1,163 @@
+// Command generate writes the rpm databases in this directory, which hold the same twelve headers in the sqlite,
+// BerkeleyDB and ndb formats. The headers only have the tags that the tests read, and one of them is large enough to
+// span several pages. rpmdb.sqlite is written with the sqlite3 command. Run it with `go generate` in pkgmanager.
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+type tag struct {
+	tag int32
+	s   string
+}
+
+// header returns a header blob as stored in the rpm database with string tags for the name, the version, the release,
+// the license and the architecture.
+func header(name, version, release, arch, license string) []byte {
+	tags := []tag{{1000, name}, {1001, version}, {1002, release}, {1014, license}, {1022, arch}}
+	var store []byte
+	var index []byte
+	for _, t := range tags {
+		e := make([]byte, 16)
+		binary.BigEndian.PutUint32(e, uint32(t.tag))
+		binary.BigEndian.PutUint32(e[4:], 6)
+		binary.BigEndian.PutUint32(e[8:], uint32(len(store)))
+		binary.BigEndian.PutUint32(e[12:], 1)
+		index = append(index, e...)
+		store = append(store, t.s...)
+		store = append(store, 0)
+	}
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint32(b, uint32(len(tags)))
+	binary.BigEndian.PutUint32(b[4:], uint32(len(store)))
+	return append(append(b, index...), store...)
+}
+
+// blobs returns the headers stored in every database.
+func blobs() [][]byte {
+	var extra [][]byte
+	// Enough packages for the sqlite table to need an interior page.
+	for i := 0; i < 10; i++ {
+		extra = append(extra, header(fmt.Sprintf("pkg%02d", i), "1.0", "1", "noarch", "MIT"))
+	}
+	return append([][]byte{
+		header("bash", "5.1.8", "9.el9", "x86_64", "GPLv3+"),
+		// A license long enough to span several pages of 512 bytes.
+		header("glibc", "2.34", "100.el9", "x86_64", strings.Repeat("LGPLv2+ and ", 90)+"GPLv2+"),
+	}, extra...)
+}
+
+const pageSize = 512
+
+// bdb writes a BerkeleyDB hash database with a metadata page, a single hash page and overflow pages holding the
+// headers. All numbers are little-endian.
+func bdb(path string) {
+	bs := blobs()
+	le := binary.LittleEndian
+	pages := [][]byte{make([]byte, pageSize), make([]byte, pageSize)}
+	meta := pages[0]
+	le.PutUint32(meta[12:], 0x061561)
+	le.PutUint32(meta[16:], 9)
+	le.PutUint32(meta[20:], pageSize)
+	meta[25] = 8
+
+	hash := pages[1]
+	le.PutUint32(hash[8:], 1)
+	hash[25] = 13
+	le.PutUint16(hash[20:], uint16(2*len(bs)))
+	top := pageSize
+	for i, b := range bs {
+		// Key item: type and the header number.
+		top -= 5
+		hash[top] = 1
+		le.PutUint32(hash[top+1:], uint32(i+1))
+		le.PutUint16(hash[26+4*i:], uint16(top))
+		// Off-page item.
+		top -= 12
+		hash[top] = 3
+		le.PutUint32(hash[top+4:], uint32(len(pages)))
+		le.PutUint32(hash[top+8:], uint32(len(b)))
+		le.PutUint16(hash[26+4*i+2:], uint16(top))
+
+		for len(b) > 0 {
+			p := make([]byte, pageSize)
+			n := copy(p[26:], b)
+			b = b[n:]
+			le.PutUint32(p[8:], uint32(len(pages)))
+			p[25] = 7
+			le.PutUint16(p[22:], uint16(n))
+			if len(b) > 0 {
+				le.PutUint32(p[16:], uint32(len(pages)+1))
+			}
+			pages = append(pages, p)
+		}
+	}
+	le.PutUint16(hash[22:], uint16(top))
+	le.PutUint32(meta[32:], uint32(len(pages)-1))
+
+	var out []byte
+	for _, p := range pages {
+		out = append(out, p...)
+	}
+	must(os.WriteFile(path, out, 0644))
+}
+
+// ndb writes an ndb database with a single slot page followed by a blob for each header.
+func ndb(path string) {
+	le := binary.LittleEndian
+	out := make([]byte, 4096)
+	le.PutUint32(out, 'R'|'p'<<8|'m'<<16|'P'<<24)
+	le.PutUint32(out[8:], 1)
+	le.PutUint32(out[12:], 1)
+	for o := 32; o < 4096; o += 16 {
+		le.PutUint32(out[o:], 'S'|'l'<<8|'o'<<16|'t'<<24)
+	}
+	for i, b := range blobs() {
+		slot := out[32+16*i:]
+		off := len(out)
+		le.PutUint32(slot[4:], uint32(i+1))
+		le.PutUint32(slot[8:], uint32(off/16))
+		blk := make([]byte, 16, 16+len(b)+16)
+		le.PutUint32(blk, 'B'|'l'<<8|'b'<<16|'S'<<24)
+		le.PutUint32(blk[4:], uint32(i+1))
+		le.PutUint32(blk[12:], uint32(len(b)))
+		blk = append(blk, b...)
+		blk = append(blk, make([]byte, 16-len(blk)%16+16)...)
+		le.PutUint32(slot[12:], uint32(len(blk)/16))
+		out = append(out, blk...)
+	}
+	must(os.WriteFile(path, out, 0644))
+}
+
+// sqlite writes an sqlite database with the Packages table of rpm, whose pages are small enough for the table to need
+// an interior page.
+func sqlite(path string) {
+	os.Remove(path)
+	sql := "PRAGMA page_size=512;\nCREATE TABLE Packages (hnum INTEGER PRIMARY KEY AUTOINCREMENT, blob BLOB NOT NULL);\n"
+	for _, b := range blobs() {
+		sql += fmt.Sprintf("INSERT INTO Packages (blob) VALUES (X'%s');\n", hex.EncodeToString(b))
+	}
+	cmd := exec.Command("sqlite3", path)
+	cmd.Stdin = strings.NewReader(sql)
+	cmd.Stderr = os.Stderr
+	must(cmd.Run())
+}
+
+func must(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
+
+func main() {
+	dir := os.Args[1]
+	bdb(dir + "/Packages")
+	ndb(dir + "/Packages.db")
+	sqlite(dir + "/rpmdb.sqlite")
+}
@@ -0,0 +1,121 @@
+package pkgmanager
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+)
+
+// Verification is the result of comparing the files installed by a package with the checksums recorded by the package
+// manager at installation time.
+type Verification struct {
+	// Modified lists the files whose content differs from the recorded checksum.
+	Modified []string `json:"modified"`
+	// Missing lists the files that no longer exist.
+	Missing []string `json:"missing"`
+	// Replaced lists the files that have been overwritten by another package, i.e. whose content matches the checksum
+	// recorded for the same path by another package.
+	Replaced []string `json:"replaced"`
+}
+
+// OK reports whether all files match their recorded checksums.
+func (v *Verification) OK() bool {
+	return len(v.Modified) == 0 && len(v.Missing) == 0 && len(v.Replaced) == 0
+}
+
+// verifyFiles compares the files recorded for each package with the files on disk under Options.Root. Each recorded file
+// must have a single checksum.
+func verifyFiles(recorded map[PackageID][]*File) (map[PackageID]*Verification, []error) {
+	owners := make(map[string][]*Checksum)
+	for _, files := range recorded {
+		for _, f := range files {
+			owners[f.Path] = append(owners[f.Path], f.Checksums[0])
+		}
+	}
+
+	verifications := make(map[PackageID]*Verification)
+	var errs []error
+	for id, files := range recorded {
+		v := &Verification{Modified: []string{}, Missing: []string{}, Replaced: []string{}}
+		for _, f := range files {
+			expected := f.Checksums[0]
+			actual, err := digestFile(rootPath(f.Path), expected.Algorithm)
+			if err != nil {
+				if os.IsNotExist(err) {
+					v.Missing = append(v.Missing, f.Path)
+				} else {
+					errs = append(errs, fmt.Errorf("failed to verify %s: %w", f.Path, err))
+				}
+				continue
+			}
+
+			if actual == expected.Value {
+				continue
+			}
+
+			replaced := false
+			for _, c := range owners[f.Path] {
+				if c != expected && c.Algorithm == expected.Algorithm && c.Value == actual {
+					replaced = true
+				}
+			}
+
+			if replaced {
+				v.Replaced = append(v.Replaced, f.Path)
+			} else {
+				v.Modified = append(v.Modified, f.Path)
+			}
+		}
+		verifications[id] = v
+	}
+
+	return verifications, errs
+}
+
+// digestFile returns the hex-encoded digest of the content of a regular file. Other types of files, such as a directory
+// in place of a recorded file, are reported by an empty digest so that they never match.
+func digestFile(path string, algorithm ChecksumAlgorithm) (string, error) {
+	var h hash.Hash
+	switch algorithm {
+	case ChecksumMD5:
+		h = md5.New()
+	case ChecksumSHA1:
+		h = sha1.New()
+	case ChecksumSHA224:
+		h = sha256.New224()
+	case ChecksumSHA256:
+		h = sha256.New()
+	case ChecksumSHA384:
+		h = sha512.New384()
+	case ChecksumSHA512:
+		h = sha512.New()
+	default:
+		return "", fmt.Errorf("unsupported checksum algorithm %s", algorithm)
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "", nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,413 @@
+package pkgmanager
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	yarnLockfileName = "yarn.lock"
+	yarnRCName       = ".yarnrc.yml"
+	// yarnDefaultRegistry is the registry that Yarn downloads npm packages from unless npmRegistryServer says otherwise.
+	yarnDefaultRegistry = "https://registry.yarnpkg.com"
+	// yarnLocalVersion is the version that Yarn Berry records for workspaces.
+	yarnLocalVersion = "0.0.0-use.local"
+)
+
+// yarn reads yarn.lock of both Yarn Classic and Yarn Berry. It embeds npm as the packages come from the same registry.
+type yarn struct {
+	npm
+}
+
+// yarnEntry is an entry of yarn.lock, which is keyed by the descriptors resolved to it, e.g.
+// `"@babel/code-frame@^7.0.0", "@babel/code-frame@^7.10.4"`. Yarn Classic writes Resolved and Yarn Berry Resolution.
+// The checksum of Yarn Berry is that of its cache archive rather than of the tarball, so it is not recorded.
+type yarnEntry struct {
+	Version              string            `yaml:"version"`
+	Resolved             string            `yaml:"resolved"`
+	Resolution           string            `yaml:"resolution"`
+	Integrity            string            `yaml:"integrity"`
+	Dependencies         map[string]string `yaml:"dependencies"`
+	OptionalDependencies map[string]string `yaml:"optionalDependencies"`
+	PeerDependencies     map[string]string `yaml:"peerDependencies"`
+	// DependenciesMeta marks the optional dependencies in Yarn Berry, which lists them in Dependencies.
+	DependenciesMeta map[string]struct {
+		Optional bool `yaml:"optional"`
+	} `yaml:"dependenciesMeta"`
+}
+
+func (y *yarn) Query() (*QueryResult, []error) {
+	return y.queryProject(projectPath())
+}
+
+func (y *yarn) QueryProjects() (map[string]*QueryResult, []error) {
+	return queryProjects([]string{yarnLockfileName}, y.queryProject)
+}
+
+func (y *yarn) String() string {
+	return "yarn"
+}
+
+func (y *yarn) Available() bool {
+	return len(findProjects([]string{yarnLockfileName})) > 0
+}
+
+// queryProject returns the packages of the project in dir.
+func (y *yarn) queryProject(dir string) (*QueryResult, []error) {
+	file, err := os.Open(filepath.Join(dir, yarnLockfileName))
+	if err != nil {
+		return nil, []error{err}
+	}
+	defer file.Close()
+
+	entries, err := y.readLockfile(file)
+	if err != nil {
+		return nil, []error{fmt.Errorf("failed to parse %s: %w", yarnLockfileName, err)}
+	}
+
+	return y.newQueryResult(y.resolveLockfile(dir, entries))
+}
+
+// readLockfile returns the entries of yarn.lock keyed by each of their descriptors. Yarn Berry writes YAML with a
+// `__metadata` entry, and Yarn Classic a format of its own.
+func (y *yarn) readLockfile(r io.Reader) (map[string]*yarnEntry, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var lockfile map[string]*yarnEntry
+	if bytes.HasPrefix(data, []byte("__metadata:")) || bytes.Contains(data, []byte("\n__metadata:")) {
+		if err := yaml.Unmarshal(data, &lockfile); err != nil {
+			return nil, err
+		}
+		delete(lockfile, "__metadata")
+	} else {
+		if lockfile, err = y.readClassicLockfile(data); err != nil {
+			return nil, err
+		}
+	}
+
+	entries := make(map[string]*yarnEntry)
+	for key, e := range lockfile {
+		for _, descriptor := range strings.Split(key, ",") {
+			entries[strings.Trim(strings.TrimSpace(descriptor), `"`)] = e
+		}
+	}
+
+	return entries, nil
+}
+
+// readClassicLockfile parses yarn.lock of Yarn Classic, which looks like YAML but separates keys and values with spaces:
+//
+//	"@babel/code-frame@^7.0.0", "@babel/code-frame@^7.10.4":
+//	  version "7.12.13"
+//	  dependencies:
+//	    "@babel/highlight" "^7.12.13"
+func (y *yarn) readClassicLockfile(data []byte) (map[string]*yarnEntry, error) {
+	lockfile := make(map[string]*yarnEntry)
+	var entry *yarnEntry
+	var section map[string]string
+
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimRight(s.Text(), " \r")
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		switch indent := len(line) - len(trimmed); indent {
+		case 0:
+			entry = &yarnEntry{}
+			lockfile[strings.TrimSuffix(trimmed, ":")] = entry
+		case 2:
+			if entry == nil {
+				return nil, fmt.Errorf("line %d: field outside an entry", n)
+			}
+			if name, ok := strings.CutSuffix(trimmed, ":"); ok {
+				section = make(map[string]string)
+				switch name {
+				case "dependencies":
+					entry.Dependencies = section
+				case "optionalDependencies":
+					entry.OptionalDependencies = section
+				case "peerDependencies":
+					entry.PeerDependencies = section
+				}
+				continue
+			}
+
+			key, value, err := y.splitClassicField(trimmed)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			switch key {
+			case "version":
+				entry.Version = value
+			case "resolved":
+				entry.Resolved = value
+			case "integrity":
+				entry.Integrity = value
+			}
+		case 4:
+			if section == nil {
+				return nil, fmt.Errorf("line %d: field outside a section", n)
+			}
+			key, value, err := y.splitClassicField(trimmed)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			section[key] = value
+		default:
+			return nil, fmt.Errorf("line %d: unexpected indentation", n)
+		}
+	}
+
+	return lockfile, s.Err()
+}
+
+// splitClassicField splits a line of Yarn Classic into the key and the value, either of which may be quoted.
+func (y *yarn) splitClassicField(field string) (string, string, error) {
+	var key string
+	if strings.HasPrefix(field, `"`) {
+		quoted, err := strconv.QuotedPrefix(field)
+		if err != nil {
+			return "", "", err
+		}
+		key, _ = strconv.Unquote(quoted)
+		field = field[len(quoted):]
+	} else {
+		var ok bool
+		if key, field, ok = strings.Cut(field, " "); !ok {
+			return "", "", fmt.Errorf("missing value of %s", key)
+		}
+	}
+
+	value := strings.TrimSpace(field)
+	if unquoted, err := strconv.Unquote(value); err == nil {
+		value = unquoted
+	}
+
+	return key, value, nil
+}
+
+// resolveLockfile returns the project in dir, its workspaces and the packages in yarn.lock with their dependencies
+// resolved.
+func (y *yarn) resolveLockfile(dir string, entries map[string]*yarnEntry) []*dependency {
+	registry := y.readRegistry(dir)
+
+	descriptors := make([]string, 0, len(entries))
+	for descriptor := range entries {
+		descriptors = append(descriptors, descriptor)
+	}
+	sort.Strings(descriptors)
+
+	deps := make(map[*yarnEntry]*dependency)
+	var ret []*dependency
+	// Workspaces are part of the project, so what they need is needed in production.
+	var prod []*dependency
+	for _, descriptor := range descriptors {
+		e := entries[descriptor]
+		if _, ok := deps[e]; ok {
+			continue
+		}
+
+		// Yarn Berry resolves the descriptor of the project itself to `<name>@workspace:.`.
+		name, reference := y.splitDescriptor(descriptor)
+		if e.Resolution != "" {
+			name, reference = y.splitDescriptor(e.Resolution)
+		}
+		// The project is found from package.json, as Yarn Classic does not record it.
+		if reference == "workspace:." {
+			continue
+		}
+
+		d := &dependency{
+			Name:         name,
+			Version:      e.Version,
+			Resolved:     e.Resolved,
+			Checksums:    y.integrityChecksums(e.Integrity),
+			Dependencies: make(map[string]*dependency),
+		}
+
+		if alias, ok := strings.CutPrefix(reference, "npm:"); ok && y.isAlias(alias) {
+			// An alias such as `foo@npm:bar@^1.0.0` installs another package.
+			d.Name, _ = y.splitDescriptor(alias)
+		}
+
+		if workspace, ok := strings.CutPrefix(reference, "workspace:"); ok {
+			d.Path = filepath.Join(dir, filepath.FromSlash(workspace))
+			d.Project = true
+			prod = append(prod, d)
+			if d.Version == yarnLocalVersion {
+				if pj, err := y.parsePackageJson(filepath.Join(d.Path, "package.json")); err == nil && pj.Version != "" {
+					d.Version = pj.Version
+				}
+			}
+		} else if pj, err := y.parsePackageJson(filepath.Join(dir, "node_modules", d.Name, "package.json")); err == nil &&
+			pj.Version == d.Version {
+			// Only the hoisted copy is found, which is the one installed by the node-modules linker.
+			d.Path = filepath.Join(dir, "node_modules", d.Name)
+		}
+
+		if d.Resolved != "" {
+			// The URL is followed by the SHA-1 of the tarball, which old lockfiles have instead of integrity.
+			var sha1 string
+			d.Resolved, sha1, _ = strings.Cut(d.Resolved, "#")
+			if _, err := hex.DecodeString(sha1); err == nil && len(sha1) == 40 && d.Checksums == nil {
+				d.Checksums = []*Checksum{{Algorithm: ChecksumSHA1, Value: sha1}}
+			}
+		} else if version, ok := strings.CutPrefix(reference, "npm:"); ok && !y.isAlias(version) {
+			_, base := y.splitToNamespaceAndName(d.Name)
+			d.Resolved = fmt.Sprintf("%s/%s/-/%s-%s.tgz", registry, d.Name, base, d.Version)
+		}
+
+		deps[e] = d
+		ret = append(ret, d)
+	}
+
+	for _, descriptor := range descriptors {
+		e := entries[descriptor]
+		d, ok := deps[e]
+		if !ok {
+			continue
+		}
+		// Yarn Berry lists the devDependencies of workspaces in Dependencies, which only package.json tells apart.
+		var dev map[string]string
+		if strings.Contains(e.Resolution, "@workspace:") {
+			if pj, err := y.parsePackageJson(filepath.Join(d.Path, "package.json")); err == nil {
+				dev = pj.DevDependencies
+			}
+		}
+		for _, required := range []struct {
+			names map[string]string
+			typ   DependencyType
+		}{
+			{e.PeerDependencies, DependencyTypePeer},
+			{e.Dependencies, DependencyTypeDependsOn},
+			{e.OptionalDependencies, DependencyTypeOptional},
+		} {
+			for name, rng := range required.names {
+				found := y.resolveDescriptor(entries, deps, name, rng)
+				if found == nil {
+					continue
+				}
+				typ := required.typ
+				if _, ok := dev[name]; ok && typ == DependencyTypeDependsOn {
+					typ = DependencyTypeDev
+				}
+				if e.DependenciesMeta[name].Optional {
+					typ = DependencyTypeOptional
+				}
+				d.addDependency(name, found, typ)
+			}
+		}
+	}
+
+	root := y.projectDependency(dir)
+	ret = append(ret, root)
+
+	// The workspaces of Yarn Classic are not in yarn.lock either, so their dependencies are found from package.json.
+	// Without it every package would be marked as dev, so nothing is.
+	if pj, err := y.parsePackageJson(filepath.Join(dir, "package.json")); err == nil {
+		for _, required := range []struct {
+			names map[string]string
+			typ   DependencyType
+		}{
+			{pj.DevDependencies, DependencyTypeDev},
+			{pj.PeerDependencies, DependencyTypePeer},
+			{pj.Dependencies, DependencyTypeDependsOn},
+			{pj.OptionalDependencies, DependencyTypeOptional},
+		} {
+			for name, rng := range required.names {
+				if found := y.resolveDescriptor(entries, deps, name, rng); found != nil {
+					root.addDependency(name, found, required.typ)
+				}
+			}
+		}
+
+		prod = append(prod, root)
+		for _, w := range y.parseWorkspaces(dir, pj) {
+			for _, required := range []map[string]string{w.Dependencies, w.OptionalDependencies, w.PeerDependencies} {
+				for name, rng := range required {
+					if found := y.resolveDescriptor(entries, deps, name, rng); found != nil {
+						prod = append(prod, found)
+					}
+				}
+			}
+		}
+		y.markDevDependencies(ret, prod)
+	}
+
+	return ret
+}
+
+// resolveDescriptor returns the package that a dependency with the range resolved to, or nil if there is none.
+func (y *yarn) resolveDescriptor(entries map[string]*yarnEntry, deps map[*yarnEntry]*dependency, name, rng string) *dependency {
+	// Yarn Berry omits the default protocol from the ranges of dependencies but not from the descriptors.
+	for _, candidate := range []string{name + "@" + rng, name + "@npm:" + rng} {
+		if e, ok := entries[candidate]; ok {
+			return deps[e]
+		}
+	}
+	return nil
+}
+
+// parseWorkspaces returns package.json of the workspaces of the project in dir.
+func (y *yarn) parseWorkspaces(dir string, pj *packageJson) []*packageJson {
+	var ret []*packageJson
+	for _, workspace := range pj.workspaces(dir) {
+		if w, err := y.parsePackageJson(filepath.Join(workspace, "package.json")); err == nil {
+			ret = append(ret, w)
+		}
+	}
+	return ret
+}
+
+// splitDescriptor splits a descriptor such as `@babel/core@npm:^7.0.0` into the name and the range or reference. The
+// range may contain @ as in `patch:resolve@^1.1.7#...`.
+func (y *yarn) splitDescriptor(descriptor string) (string, string) {
+	start := 0
+	if strings.HasPrefix(descriptor, "@") {
+		start = strings.Index(descriptor, "/") + 1
+	}
+	i := strings.Index(descriptor[start:], "@")
+	if i < 0 {
+		return descriptor, ""
+	}
+
+	return descriptor[:start+i], descriptor[start+i+1:]
+}
+
+// isAlias reports whether the range of the npm protocol names another package, as in `bar@^1.0.0`.
+func (y *yarn) isAlias(rng string) bool {
+	return strings.Contains(strings.TrimPrefix(rng, "@"), "@")
+}
+
+// readRegistry returns the registry configured in .yarnrc.yml of Yarn Berry in dir, which the URLs of npm packages are
+// derived from as yarn.lock does not record them.
+func (y *yarn) readRegistry(dir string) string {
+	data, err := os.ReadFile(filepath.Join(dir, yarnRCName))
+	if err != nil {
+		return yarnDefaultRegistry
+	}
+
+	var rc struct {
+		NpmRegistryServer string `yaml:"npmRegistryServer"`
+	}
+	if err := yaml.Unmarshal(data, &rc); err != nil || rc.NpmRegistryServer == "" {
+		return yarnDefaultRegistry
+	}
+
+	return strings.TrimSuffix(rc.NpmRegistryServer, "/")
+}
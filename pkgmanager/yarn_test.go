@@ -0,0 +1,247 @@
+package pkgmanager
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func resolveYarnTestLockfile(t *testing.T, dir, lockfile string) []*dependency {
+	t.Helper()
+	y := &yarn{}
+	entries, err := y.readLockfile(strings.NewReader(lockfile))
+	if err != nil {
+		t.Fatalf("readLockfile() error = %v", err)
+	}
+	return y.resolveLockfile(dir, entries)
+}
+
+func TestYarnClassicLockfile(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"package.json": `{
+			"name": "app",
+			"version": "1.0.0",
+			"dependencies": {"a": "^1.0.0", "alias": "npm:b@^2.0.0"},
+			"devDependencies": {"d": "^1.0.0"},
+			"optionalDependencies": {"o": "^1.0.0"}
+		}`,
+	})
+
+	deps := resolveYarnTestLockfile(t, dir, `# THIS IS AN AUTOGENERATED FILE. DO NOT EDIT THIS FILE DIRECTLY.
+# yarn lockfile v1
+
+
+a@^1.0.0, a@^1.1.0:
+  version "1.1.0"
+  resolved "https://registry.yarnpkg.com/a/-/a-1.1.0.tgz#0123456789abcdef0123456789abcdef01234567"
+  dependencies:
+    b "^1.0.0"
+
+"alias@npm:b@^2.0.0":
+  version "2.0.0"
+  resolved "https://registry.yarnpkg.com/b/-/b-2.0.0.tgz"
+  integrity sha1-AAAAAAAAAAAAAAAAAAAAAAAAAAA=
+
+b@^1.0.0:
+  version "1.0.0"
+  resolved "https://registry.yarnpkg.com/b/-/b-1.0.0.tgz"
+
+d@^1.0.0:
+  version "1.0.0"
+  dependencies:
+    b "^1.0.0"
+  optionalDependencies:
+    o "^1.0.0"
+
+o@^1.0.0:
+  version "1.0.0"
+`)
+
+	want := []dependencySummary{
+		{id: "a@1.1.0", deps: []string{"b=b@1.0.0"}},
+		{id: "app@1.0.0", project: true, deps: []string{
+			"a=a@1.1.0", "alias=b@2.0.0", "d=d@1.0.0 DEV", "o=o@1.0.0 OPTIONAL",
+		}},
+		{id: "b@1.0.0"},
+		{id: "b@2.0.0"},
+		{id: "d@1.0.0", dev: true, deps: []string{"b=b@1.0.0", "o=o@1.0.0 OPTIONAL"}},
+		{id: "o@1.0.0"},
+	}
+	if got := summarizeDependencies(deps); !reflect.DeepEqual(got, want) {
+		t.Errorf("resolveLockfile() = %+v, want %+v", got, want)
+	}
+
+	for _, d := range deps {
+		var want []*Checksum
+		switch d.Name + "@" + d.Version {
+		case "a@1.1.0":
+			// The SHA-1 after the URL is the checksum when there is no integrity.
+			if d.Resolved != "https://registry.yarnpkg.com/a/-/a-1.1.0.tgz" {
+				t.Errorf("Resolved of a = %q, want it without the fragment", d.Resolved)
+			}
+			want = []*Checksum{{Algorithm: ChecksumSHA1, Value: "0123456789abcdef0123456789abcdef01234567"}}
+		case "b@2.0.0":
+			want = []*Checksum{{Algorithm: ChecksumSHA1, Value: strings.Repeat("0", 40)}}
+		default:
+			continue
+		}
+		if !reflect.DeepEqual(d.Checksums, want) {
+			t.Errorf("Checksums of %s = %v, want %v", d.Name, d.Checksums, want)
+		}
+	}
+}
+
+func TestYarnBerryLockfile(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"package.json": `{
+			"name": "app",
+			"version": "1.0.0",
+			"workspaces": ["packages/*"],
+			"dependencies": {"a": "^1.0.0"},
+			"devDependencies": {"d": "^1.0.0"}
+		}`,
+		"packages/ws/package.json": `{
+			"name": "ws",
+			"version": "2.0.0",
+			"dependencies": {"b": "^1.0.0"},
+			"devDependencies": {"d": "^1.0.0"}
+		}`,
+		".yarnrc.yml": "npmRegistryServer: \"https://npm.example.com/\"\n",
+	})
+
+	deps := resolveYarnTestLockfile(t, dir, `# This file is generated by running "yarn install" inside your project.
+
+__metadata:
+  version: 6
+  cacheKey: 8
+
+"@scope/s@npm:^1.0.0":
+  version: 1.0.0
+  resolution: "@scope/s@npm:1.0.0"
+  checksum: 0123
+  languageName: node
+  linkType: hard
+
+"a@npm:^1.0.0":
+  version: 1.1.0
+  resolution: "a@npm:1.1.0"
+  dependencies:
+    "@scope/s": ^1.0.0
+    b: ^1.0.0
+    o: ^1.0.0
+  peerDependencies:
+    p: ^1.0.0
+  dependenciesMeta:
+    o:
+      optional: true
+  languageName: node
+  linkType: hard
+
+"app@workspace:.":
+  version: 0.0.0-use.local
+  resolution: "app@workspace:."
+  dependencies:
+    a: ^1.0.0
+    d: ^1.0.0
+  languageName: unknown
+  linkType: soft
+
+"b@npm:^1.0.0":
+  version: 1.0.0
+  resolution: "b@npm:1.0.0"
+  languageName: node
+  linkType: hard
+
+"d@npm:^1.0.0":
+  version: 1.0.0
+  resolution: "d@npm:1.0.0"
+  languageName: node
+  linkType: hard
+
+"o@npm:^1.0.0":
+  version: 1.0.0
+  resolution: "o@npm:1.0.0"
+  languageName: node
+  linkType: hard
+
+"p@npm:^1.0.0":
+  version: 1.0.0
+  resolution: "p@npm:1.0.0"
+  languageName: node
+  linkType: hard
+
+"ws@workspace:packages/ws":
+  version: 0.0.0-use.local
+  resolution: "ws@workspace:packages/ws"
+  dependencies:
+    b: ^1.0.0
+    d: ^1.0.0
+  languageName: unknown
+  linkType: soft
+`)
+
+	want := []dependencySummary{
+		{id: "@scope/s@1.0.0"},
+		{id: "a@1.1.0", deps: []string{"@scope/s=@scope/s@1.0.0", "b=b@1.0.0", "o=o@1.0.0 OPTIONAL", "p=p@1.0.0 PEER"}},
+		{id: "app@1.0.0", project: true, deps: []string{"a=a@1.1.0", "d=d@1.0.0 DEV"}},
+		{id: "b@1.0.0"},
+		{id: "d@1.0.0", dev: true},
+		{id: "o@1.0.0"},
+		{id: "p@1.0.0"},
+		{id: "ws@2.0.0", project: true, deps: []string{"b=b@1.0.0", "d=d@1.0.0 DEV"}},
+	}
+	if got := summarizeDependencies(deps); !reflect.DeepEqual(got, want) {
+		t.Errorf("resolveLockfile() = %+v, want %+v", got, want)
+	}
+
+	// Yarn Berry does not record the URLs of tarballs, which come from the registry in .yarnrc.yml.
+	for _, d := range deps {
+		var want string
+		switch d.Name {
+		case "@scope/s":
+			want = "https://npm.example.com/@scope/s/-/s-1.0.0.tgz"
+		case "a":
+			want = "https://npm.example.com/a/-/a-1.1.0.tgz"
+		default:
+			continue
+		}
+		if d.Resolved != want {
+			t.Errorf("Resolved of %s = %q, want %q", d.Name, d.Resolved, want)
+		}
+	}
+}
+
+func TestYarnReadClassicLockfileErrors(t *testing.T) {
+	for _, tt := range []struct {
+		lockfile string
+		wantErr  string
+	}{
+		{"  version \"1.0.0\"\n", "line 1: field outside an entry"},
+		{"a@^1.0.0:\n    b \"^1.0.0\"\n", "line 2: field outside a section"},
+		{"a@^1.0.0:\n   version \"1.0.0\"\n", "line 2: unexpected indentation"},
+		{"a@^1.0.0:\n  version\n", "line 2: missing value of version"},
+	} {
+		_, err := (&yarn{}).readLockfile(strings.NewReader(tt.lockfile))
+		if err == nil || err.Error() != tt.wantErr {
+			t.Errorf("readLockfile(%q) error = %v, want %q", tt.lockfile, err, tt.wantErr)
+		}
+	}
+}
+
+func TestYarnSplitDescriptor(t *testing.T) {
+	for _, tt := range []struct {
+		descriptor, name, rng string
+	}{
+		{"a@^1.0.0", "a", "^1.0.0"},
+		{"@babel/core@npm:^7.0.0", "@babel/core", "npm:^7.0.0"},
+		{"alias@npm:@scope/real@^3.0.0", "alias", "npm:@scope/real@^3.0.0"},
+		{"resolve@patch:resolve@^1.1.7#~builtin<compat/resolve>", "resolve", "patch:resolve@^1.1.7#~builtin<compat/resolve>"},
+		{"app", "app", ""},
+	} {
+		if name, rng := (&yarn{}).splitDescriptor(tt.descriptor); name != tt.name || rng != tt.rng {
+			t.Errorf("splitDescriptor(%q) = %q, %q, want %q, %q", tt.descriptor, name, rng, tt.name, tt.rng)
+		}
+	}
+}
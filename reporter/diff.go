@@ -38,8 +38,23 @@ func (d *Diff) diff(doc *spdx.Document) {
 		if basePackages[p.PackageSPDXIdentifier] == nil {
 			packages = append(packages, p)
 			packageIds[p.PackageSPDXIdentifier] = struct{}{}
-			otherLicenseIds[p.PackageLicenseDeclared] = struct{}{}
-			otherLicenseIds[p.PackageLicenseConcluded] = struct{}{}
+			for _, licenseRef := range sbom.LicenseRefs(p.PackageLicenseDeclared + " " + p.PackageLicenseConcluded) {
+				otherLicenseIds[licenseRef] = struct{}{}
+			}
+		}
+	}
+
+	// Files are kept along with the packages that contain them.
+	var files []*spdx.File
+	fileIds := make(map[spdx.ElementID]struct{})
+	for _, r := range doc.Relationships {
+		if _, ok := packageIds[r.RefA.ElementRefID]; ok && r.Relationship == spdx.RelationshipContains {
+			fileIds[r.RefB.ElementRefID] = struct{}{}
+		}
+	}
+	for _, f := range doc.Files {
+		if _, ok := fileIds[f.FileSPDXIdentifier]; ok {
+			files = append(files, f)
 		}
 	}
 
@@ -47,7 +62,8 @@ func (d *Diff) diff(doc *spdx.Document) {
 	for _, r := range doc.Relationships {
 		_, okA := packageIds[r.RefA.ElementRefID]
 		_, okB := packageIds[r.RefB.ElementRefID]
-		if okA && okB {
+		_, okFile := fileIds[r.RefB.ElementRefID]
+		if okA && (okB || okFile) {
 			relationships = append(relationships, r)
 		}
 	}
@@ -60,6 +76,7 @@ func (d *Diff) diff(doc *spdx.Document) {
 	}
 
 	doc.Packages = packages
+	doc.Files = files
 	doc.Relationships = relationships
 	doc.OtherLicenses = otherLicenses
 }
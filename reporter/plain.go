@@ -20,6 +20,10 @@ func (p *Plain) Report() (string, error) {
 	var ret bytes.Buffer
 
 	for _, r := range p.Spirat.Results {
+		if r.Path != "" {
+			color.New(color.Bold).Fprintf(&ret, "Project: %s (%s)\n", r.Path, r.PackageManager)
+		}
+
 		for _, pkg := range r.QueryResult.Packages {
 			color.New(color.FgGreen).Fprint(&ret, pkg.Name)
 
@@ -49,6 +53,37 @@ func (p *Plain) Report() (string, error) {
 				fmt.Fprintf(&ret, "  License: Not found\n")
 			}
 
+			if pkg.Supplier != nil {
+				fmt.Fprintf(&ret, "  Supplier: %s\n", pkg.Supplier.Name)
+			}
+
+			if s := pkg.Signature; s != nil {
+				if s.Signed() && s.Algorithm != "" {
+					fmt.Fprintf(&ret, "  Signature: %s, Key ID %s\n", s.Algorithm, s.KeyID)
+				} else if s.Signed() {
+					fmt.Fprintf(&ret, "  Signature: Key ID %s\n", s.KeyID)
+				} else {
+					fmt.Fprint(&ret, "  Signature: ")
+					color.New(color.FgRed).Fprint(&ret, "Not signed")
+					fmt.Fprint(&ret, "\n")
+				}
+			}
+
+			if v := pkg.Verification; v != nil {
+				if v.OK() {
+					fmt.Fprintf(&ret, "  Verification: OK\n")
+				}
+				for _, path := range v.Modified {
+					fmt.Fprintf(&ret, "  Modified: %s\n", path)
+				}
+				for _, path := range v.Missing {
+					fmt.Fprintf(&ret, "  Missing: %s\n", path)
+				}
+				for _, path := range v.Replaced {
+					fmt.Fprintf(&ret, "  Replaced: %s\n", path)
+				}
+			}
+
 			fmt.Fprint(&ret, "\n")
 		}
 	}
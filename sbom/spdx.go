@@ -1,19 +1,28 @@
 package sbom
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
 	"fmt"
 	"github.com/Hitachi/spirat/pkgmanager"
 	"github.com/mitchellh/hashstructure/v2"
 	"github.com/spdx/tools-golang/spdx"
+	"regexp"
+	"sort"
 	"strings"
 	"time"
 )
 
 const (
 	ElementPackage = "Package"
+	ElementFile    = "File"
 	NOASSERTION    = "NOASSERTION"
+	// PurposeApplication is the primary purpose of the package of the project that was scanned.
+	PurposeApplication = "APPLICATION"
 )
 
+var licenseRefRe = regexp.MustCompile(`LicenseRef-[A-Za-z0-9.-]+`)
+
 func ToSpdx(qrs []*pkgmanager.QueryResult) *spdx.Document {
 	var doc spdx.Document
 	doc.SPDXVersion = spdx.Version
@@ -25,35 +34,117 @@ func ToSpdx(qrs []*pkgmanager.QueryResult) *spdx.Document {
 		Creators: []spdx.Creator{{Creator: "spirat", CreatorType: "Tool"}},
 	}
 
+	sources := make(map[pkgmanager.PackageID]struct{})
+	licenseRefs := make(map[string]struct{})
+	// Projects may share packages, which must appear only once in the document along with their relationships.
+	packages := make(map[pkgmanager.PackageID]*spdx.Package)
+	relationships := make(map[spdx.Relationship]struct{})
+	addRelationship := func(r *spdx.Relationship) {
+		if _, ok := relationships[*r]; !ok {
+			relationships[*r] = struct{}{}
+			doc.Relationships = append(doc.Relationships, r)
+		}
+	}
 	for _, r := range qrs {
+		roots := make(map[pkgmanager.PackageID]struct{})
+		for _, id := range r.Roots {
+			roots[id] = struct{}{}
+		}
+
 		for _, pkg := range r.Packages {
-			spdxPkg, _ := toSpdxPackage(pkg)
-			doc.Packages = append(doc.Packages, spdxPkg)
-			doc.Relationships = append(doc.Relationships, &spdx.Relationship{
-				RefA:         spdx.DocElementID{ElementRefID: doc.SPDXIdentifier},
-				RefB:         spdx.DocElementID{ElementRefID: spdxPkg.PackageSPDXIdentifier},
-				Relationship: spdx.RelationshipDescribes,
-			})
-
-			if len(pkg.Licenses) == 0 {
+			spdxPkg, seen := packages[pkg.ID]
+			if !seen {
+				spdxPkg, _ = toSpdxPackage(pkg)
+				packages[pkg.ID] = spdxPkg
+				doc.Packages = append(doc.Packages, spdxPkg)
+			}
+
+			// The document describes the project if there is one, and the rest are found through its dependencies.
+			_, root := roots[pkg.ID]
+			if root {
+				spdxPkg.PrimaryPackagePurpose = PurposeApplication
+			}
+			if root || len(roots) == 0 {
+				addRelationship(&spdx.Relationship{
+					RefA:         spdx.DocElementID{ElementRefID: doc.SPDXIdentifier},
+					RefB:         spdx.DocElementID{ElementRefID: spdxPkg.PackageSPDXIdentifier},
+					Relationship: spdx.RelationshipDescribes,
+				})
+			}
+			if seen {
+				continue
+			}
+
+			if len(pkg.Files) > 0 {
+				spdxPkg.FilesAnalyzed = true
+				spdxPkg.PackageVerificationCode = verificationCode(pkg.Files)
+				for _, file := range pkg.Files {
+					spdxFile := toSpdxFile(pkg, file)
+					doc.Files = append(doc.Files, spdxFile)
+					addRelationship(&spdx.Relationship{
+						RefA:         spdx.DocElementID{ElementRefID: spdxPkg.PackageSPDXIdentifier},
+						RefB:         spdx.DocElementID{ElementRefID: spdxFile.FileSPDXIdentifier},
+						Relationship: spdx.RelationshipContains,
+					})
+				}
+			}
+
+			if pkg.Source != nil {
+				// Binary packages built from the same source share a single source package element.
+				if _, ok := sources[pkg.Source.ID]; !ok {
+					sources[pkg.Source.ID] = struct{}{}
+					doc.Packages = append(doc.Packages, toSpdxSourcePackage(pkg.Source))
+				}
+				addRelationship(&spdx.Relationship{
+					RefA:         spdx.DocElementID{ElementRefID: spdxPkg.PackageSPDXIdentifier},
+					RefB:         spdx.DocElementID{ElementRefID: packageId(pkg.Source.ID)},
+					Relationship: spdx.RelationshipGeneratedFrom,
+				})
+			}
+
+			// Licenses without an SPDX identifier are referenced as LicenseRefs, which must be declared in the
+			// document along with the license names they stand for.
+			for _, license := range pkg.Licenses {
+				names := pkgmanager.LicenseRefNames(license.Name)
+				for _, licenseRef := range LicenseRefs(license.SpdxExpression) {
+					if _, ok := licenseRefs[licenseRef]; ok {
+						continue
+					}
+					licenseRefs[licenseRef] = struct{}{}
+					name, ok := names[licenseRef]
+					if !ok {
+						name = license.Name
+					}
+					doc.OtherLicenses = append(doc.OtherLicenses, &spdx.OtherLicense{
+						LicenseIdentifier: licenseRef,
+						LicenseName:       name,
+						ExtractedText:     name,
+					})
+				}
+			}
+
+			// A package without a declared license is under all of the license files that it ships.
+			if len(pkg.Licenses) == 0 && len(pkg.LicenseFiles) > 0 {
+				var fileRefs []string
 				for _, file := range pkg.LicenseFiles {
 					h, _ := hashstructure.Hash(file.Path, hashstructure.FormatV2, nil)
 					licenseRef := fmt.Sprintf("LicenseRef-%x", h)
-					spdxPkg.PackageLicenseDeclared = licenseRef
+					fileRefs = append(fileRefs, licenseRef)
+					if _, ok := licenseRefs[licenseRef]; ok {
+						continue
+					}
+					licenseRefs[licenseRef] = struct{}{}
 					doc.OtherLicenses = append(doc.OtherLicenses, &spdx.OtherLicense{
 						LicenseIdentifier: licenseRef,
 						ExtractedText:     file.Content,
 					})
 				}
+				spdxPkg.PackageLicenseDeclared = strings.Join(fileRefs, " AND ")
 			}
 		}
 
 		for _, dep := range r.Dependencies {
-			doc.Relationships = append(doc.Relationships, &spdx.Relationship{
-				RefA:         spdx.DocElementID{ElementRefID: packageId(dep.RequiringPackageID)},
-				RefB:         spdx.DocElementID{ElementRefID: packageId(dep.RequiredPackageID)},
-				Relationship: spdx.RelationshipDependsOn,
-			})
+			addRelationship(dependencyRelationship(dep))
 		}
 	}
 
@@ -66,9 +157,22 @@ func toSpdxPackage(p *pkgmanager.Package) (*spdx.Package, error) {
 	spdxPkg.PackageName = p.Name
 	spdxPkg.PackageVersion = p.Version
 	spdxPkg.PackageHomePage = p.HomepageUrl
-	spdxPkg.PackageDownloadLocation = p.DownloadUrl
+	spdxPkg.PackageDownloadLocation = NOASSERTION
+	if p.DownloadUrl != "" {
+		spdxPkg.PackageDownloadLocation = p.DownloadUrl
+	}
 	spdxPkg.PackageSourceInfo = p.SourceInfo
 	spdxPkg.PackageLicenseDeclared = spdxLicense(p.Licenses)
+	for _, c := range p.Checksums {
+		spdxPkg.PackageChecksums = append(spdxPkg.PackageChecksums, spdx.Checksum{
+			Algorithm: spdx.ChecksumAlgorithm(c.Algorithm),
+			Value:     c.Value,
+		})
+	}
+	spdxPkg.PackageCopyrightText = NOASSERTION
+	if p.CopyrightText != "" {
+		spdxPkg.PackageCopyrightText = p.CopyrightText
+	}
 	spdxPkg.PackageExternalReferences = []*spdx.PackageExternalReference{
 		{
 			Category: spdx.CategoryPackageManager,
@@ -76,24 +180,198 @@ func toSpdxPackage(p *pkgmanager.Package) (*spdx.Package, error) {
 			Locator:  p.PackageURL.String(),
 		},
 	}
+	if p.Supplier != nil {
+		spdxPkg.PackageSupplier = &spdx.Supplier{Supplier: p.Supplier.Name, SupplierType: string(p.Supplier.Type)}
+	}
+	if p.BuildTime != nil {
+		spdxPkg.BuiltDate = p.BuildTime.UTC().Format(time.RFC3339)
+	}
+	if p.BuildHost != "" {
+		spdxPkg.PackageComment = fmt.Sprintf("Built on %s.", p.BuildHost)
+	}
+	if p.Signature != nil {
+		spdxPkg.Annotations = append(spdxPkg.Annotations, signatureAnnotation(p))
+	}
+	if p.Verification != nil {
+		spdxPkg.Annotations = append(spdxPkg.Annotations, verificationAnnotation(p))
+	}
 
 	return &spdxPkg, nil
 }
 
+// signatureAnnotation records the key that signed a package, or that the package is not signed.
+func signatureAnnotation(p *pkgmanager.Package) spdx.Annotation {
+	comment := "The package is not signed."
+	if p.Signature.Signed() {
+		comment = fmt.Sprintf("Signed with key ID %s.", p.Signature.KeyID)
+		if p.Signature.Algorithm != "" {
+			comment = fmt.Sprintf("Signed with key ID %s using %s.", p.Signature.KeyID, p.Signature.Algorithm)
+		}
+	}
+
+	return toolAnnotation(p, comment)
+}
+
+// verificationAnnotation summarizes the result of verifying the installed files of a package.
+func verificationAnnotation(p *pkgmanager.Package) spdx.Annotation {
+	comment := "All installed files match the checksums recorded by the package manager."
+	if !p.Verification.OK() {
+		var lines []string
+		for _, path := range p.Verification.Modified {
+			lines = append(lines, "Modified: "+path)
+		}
+		for _, path := range p.Verification.Missing {
+			lines = append(lines, "Missing: "+path)
+		}
+		for _, path := range p.Verification.Replaced {
+			lines = append(lines, "Replaced: "+path)
+		}
+		comment = strings.Join(lines, "\n")
+	}
+
+	return toolAnnotation(p, comment)
+}
+
+func toolAnnotation(p *pkgmanager.Package, comment string) spdx.Annotation {
+	return spdx.Annotation{
+		Annotator:                spdx.Annotator{Annotator: "spirat", AnnotatorType: "Tool"},
+		AnnotationDate:           time.Now().Format(time.RFC3339),
+		AnnotationType:           "OTHER",
+		AnnotationSPDXIdentifier: spdx.DocElementID{ElementRefID: packageId(p.ID)},
+		AnnotationComment:        comment,
+	}
+}
+
+func toSpdxSourcePackage(s *pkgmanager.SourcePackage) *spdx.Package {
+	var spdxPkg spdx.Package
+	spdxPkg.PackageSPDXIdentifier = packageId(s.ID)
+	spdxPkg.PackageName = s.Name
+	spdxPkg.PackageVersion = s.Version
+	spdxPkg.PackageDownloadLocation = NOASSERTION
+	spdxPkg.PackageLicenseDeclared = NOASSERTION
+	spdxPkg.PackageCopyrightText = NOASSERTION
+	spdxPkg.PrimaryPackagePurpose = "SOURCE"
+	spdxPkg.PackageExternalReferences = []*spdx.PackageExternalReference{
+		{
+			Category: spdx.CategoryPackageManager,
+			RefType:  spdx.PackageManagerPURL,
+			Locator:  s.PackageURL.String(),
+		},
+	}
+
+	return &spdxPkg
+}
+
+func toSpdxFile(p *pkgmanager.Package, f *pkgmanager.File) *spdx.File {
+	var spdxFile spdx.File
+	h, _ := hashstructure.Hash([]string{string(p.ID), f.Path}, hashstructure.FormatV2, nil)
+	spdxFile.FileSPDXIdentifier = spdx.ElementID(fmt.Sprintf("%s-%x", ElementFile, h))
+	spdxFile.FileName = f.Path
+	spdxFile.LicenseConcluded = NOASSERTION
+	spdxFile.FileCopyrightText = NOASSERTION
+	for _, c := range f.Checksums {
+		spdxFile.Checksums = append(spdxFile.Checksums, spdx.Checksum{
+			Algorithm: spdx.ChecksumAlgorithm(c.Algorithm),
+			Value:     c.Value,
+		})
+	}
+
+	return &spdxFile
+}
+
+// verificationCode computes the package verification code, which is the SHA-1 digest of the sorted and concatenated
+// SHA-1 digests of the files in the package.
+func verificationCode(files []*pkgmanager.File) *spdx.PackageVerificationCode {
+	var sums []string
+	for _, f := range files {
+		for _, c := range f.Checksums {
+			if c.Algorithm == pkgmanager.ChecksumSHA1 {
+				sums = append(sums, c.Value)
+			}
+		}
+	}
+	sort.Strings(sums)
+
+	h := sha1.Sum([]byte(strings.Join(sums, "")))
+	return &spdx.PackageVerificationCode{Value: hex.EncodeToString(h[:])}
+}
+
+// dependencyRelationship converts dep into an SPDX relationship. Dependencies that the requiring package works without
+// are expressed as optional dependencies of it.
+func dependencyRelationship(dep *pkgmanager.PackageDependency) *spdx.Relationship {
+	requiring := spdx.DocElementID{ElementRefID: packageId(dep.RequiringPackageID)}
+	required := spdx.DocElementID{ElementRefID: packageId(dep.RequiredPackageID)}
+
+	switch dep.DependencyType {
+	case pkgmanager.DependencyTypeRecommends, pkgmanager.DependencyTypeSuggests, pkgmanager.DependencyTypeWeak,
+		pkgmanager.DependencyTypeOptional:
+		return &spdx.Relationship{
+			RefA:         required,
+			RefB:         requiring,
+			Relationship: spdx.RelationshipOptionalDependencyOf,
+		}
+	case pkgmanager.DependencyTypeDev:
+		return &spdx.Relationship{
+			RefA:         required,
+			RefB:         requiring,
+			Relationship: spdx.RelationshipDevDependencyOf,
+		}
+	case pkgmanager.DependencyTypePeer:
+		// A peer dependency is provided by the package that depends on the requiring package.
+		return &spdx.Relationship{
+			RefA:         required,
+			RefB:         requiring,
+			Relationship: spdx.RelationshipProvidedDependencyOf,
+		}
+	case pkgmanager.DependencyTypeBundled:
+		return &spdx.Relationship{
+			RefA:         requiring,
+			RefB:         required,
+			Relationship: spdx.RelationshipContains,
+		}
+	default:
+		return &spdx.Relationship{
+			RefA:         requiring,
+			RefB:         required,
+			Relationship: spdx.RelationshipDependsOn,
+		}
+	}
+}
+
 func packageId(id pkgmanager.PackageID) spdx.ElementID {
 	return spdx.ElementID(ElementPackage + "-" + id)
 }
 
+// spdxLicense joins the SPDX expressions of licenses that all apply to a package into a single expression.
 func spdxLicense(licenses []*pkgmanager.License) string {
-	if len(licenses) == 0 {
-		return NOASSERTION
+	var expressions []string
+	seen := make(map[string]struct{})
+
+	for _, license := range licenses {
+		expression := license.SpdxExpression
+		if _, ok := seen[expression]; ok || expression == "" {
+			continue
+		}
+		seen[expression] = struct{}{}
+		expressions = append(expressions, expression)
 	}
 
-	var licenseNames []string
+	if len(expressions) == 0 {
+		return NOASSERTION
+	}
 
-	for _, license := range licenses {
-		licenseNames = append(licenseNames, license.Name)
+	if len(expressions) > 1 {
+		for i, expression := range expressions {
+			if strings.Contains(expression, " OR ") {
+				expressions[i] = "(" + expression + ")"
+			}
+		}
 	}
 
-	return strings.Join(licenseNames, " and ")
+	return strings.Join(expressions, " AND ")
+}
+
+// LicenseRefs returns the LicenseRef identifiers used in an SPDX license expression.
+func LicenseRefs(expression string) []string {
+	return licenseRefRe.FindAllString(expression, -1)
 }
@@ -3,46 +3,19 @@
  * All rights reserved
  */
 
-package main
 package spirat
 
 import "github.com/Hitachi/spirat/pkgmanager"
 
-func CoreSpirat(string, string){
-
- type Spirat struct {
+type Spirat struct {
 	Command string    `json:"command"`
 	Version string    `json:"version"`
 	Results []*Result `json:"results"`
- }
-
- type Result struct {
-	PackageManager string                  `json:"packageManager"`
-	QueryResult    *pkgmanager.QueryResult `json:"queryResult"`
- }
-
- for Spirat = string | int; Spirat == true; Spirat{
-    return
- } 
-  if Spirat == false{
-    return Spirat == Spirat
- }
-
- for Result = string | int; Result == true; Result{
-    return
- } 
-  if Result == false{
-    return Result == Result
-  }
-
 }
 
-func main(){
-  CoreSpirat := CoreSpirat
-  if CoreSpirat == CoreSpirat{
-    return  
-  }  
-   if !true || !false{
-    return CoreSpirat()
-   }
+type Result struct {
+	PackageManager string `json:"packageManager"`
+	// Path is the directory of the project that the packages were found in, for package managers of projects.
+	Path        string                  `json:"path,omitempty"`
+	QueryResult *pkgmanager.QueryResult `json:"queryResult"`
 }
@@ -3,15 +3,28 @@ package sysinfo
 import (
 	"bufio"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
 type OSRelease struct {
-	ID string
+	ID        string
+	VersionID string
 }
 
-func NewOSRelease() OSRelease {
-	f, err := os.Open("/etc/os-release")
+// Distro returns the distribution with its version, e.g. `debian-12`, as used in the `distro` qualifier of package
+// URLs. Rolling releases without a version are identified by the ID alone.
+func (o OSRelease) Distro() string {
+	if o.VersionID == "" {
+		return o.ID
+	}
+
+	return o.ID + "-" + o.VersionID
+}
+
+// NewOSRelease reads /etc/os-release of the system whose file system is found at root.
+func NewOSRelease(root string) OSRelease {
+	f, err := os.Open(filepath.Join(root, "/etc/os-release"))
 	if err != nil {
 		return OSRelease{}
 	}
@@ -28,6 +41,7 @@ func NewOSRelease() OSRelease {
 	}
 
 	return OSRelease{
-		ID: osRelease["ID"],
+		ID:        osRelease["ID"],
+		VersionID: osRelease["VERSION_ID"],
 	}
 }
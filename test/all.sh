@@ -8,8 +8,8 @@ FAILED=0
 
 run-test() {
   IMAGE_NAME="${1%:*}"
-  FILE_NAME="${IMAGE_NAME/\//_}"
   TOOL="$2"
+  FILE_NAME="${IMAGE_NAME/\//_}_$TOOL"
 
   PREPARE_CMD="$3"
   if [[ "$PREPARE_CMD" != "" ]]; then
@@ -38,6 +38,35 @@ run-test() {
   fi
 }
 
+# run-fixture-test runs spirat in a project under test/fixtures and compares the licenses that it finds with
+# expected.txt of the project.
+run-fixture-test() {
+  TOOL="$2"
+  FIXTURE="test/fixtures/$3"
+  echo "Testing with: $FIXTURE"
+
+  docker run --rm \
+    -v "$PWD/build/spirat:/usr/bin/spirat" \
+    -v "$PWD/$FIXTURE:/fixture:ro" \
+    -w /fixture \
+    "$1" bash -c "spirat -tools=$TOOL -stdout -format=json | node -e '
+      const spirat = JSON.parse(require(\"fs\").readFileSync(0));
+      for (const r of spirat.results)
+        for (const [id, p] of Object.entries(r.queryResult.packages))
+          console.log(id + \"\\t\" + p.licenses.map(l => l.spdxExpression).join(\" AND \"));
+    '" \
+    | LC_ALL=C sort \
+    | diff "$FIXTURE/expected.txt" -
+  STATUS=("${PIPESTATUS[@]}")
+
+  if [[ ${STATUS[0]} = 0 && ${STATUS[2]} = 0 ]]; then
+    echo "OK: $FIXTURE"
+  else
+    echo "${ESC}[31mFAILED${ESC}[m: $FIXTURE"
+    FAILED=1
+  fi
+}
+
 make
 
 run-test almalinux:8.4            rpm
@@ -46,8 +75,13 @@ run-test centos:8                 rpm
 run-test debian:11.1              dpkg
 run-test opensuse/leap            rpm
 run-test oraclelinux:8.4          rpm
+run-test rockylinux:9             rpm
 run-test ubuntu:20.04             dpkg
 run-test node:20-bullseye-slim    npm   "cd; npm install react"
+run-test node:20-bullseye-slim    yarn  "cd; yarn add react"
+run-test node:20-bullseye-slim    pnpm  "cd; npm install -g pnpm; pnpm add react"
+
+run-fixture-test node:20-bullseye-slim npm npm-licenses
 
 if [[ $FAILED = 1 ]]; then
   echo
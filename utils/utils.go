@@ -1,5 +1,7 @@
 package utils
 
+import "sort"
+
 func Map[T, V any](ts []T, f func(T) V) []V {
 	result := make([]V, len(ts))
 	for i, t := range ts {
@@ -7,3 +9,13 @@ func Map[T, V any](ts []T, f func(T) V) []V {
 	}
 	return result
 }
+
+// SortedKeys returns the keys of m in ascending order.
+func SortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}